@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// 发布载荷以 "AXMQ" + 8 字节发送时间戳 (UnixNano) 开头，订阅端据此计算端到端延迟。
+// 跨机器测量延迟时需保证两端时钟同步。
+var payloadMagic = [4]byte{'A', 'X', 'M', 'Q'}
+
+const payloadHeaderLen = 12
+
+func stampPayload(buf []byte, now time.Time) {
+	if len(buf) < payloadHeaderLen {
+		return
+	}
+	copy(buf, payloadMagic[:])
+	binary.BigEndian.PutUint64(buf[4:], uint64(now.UnixNano()))
+}
+
+func payloadSentAt(p []byte) (time.Time, bool) {
+	if len(p) < payloadHeaderLen || [4]byte(p[:4]) != payloadMagic {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(p[4:]))), true
+}
+
+type bench struct {
+	o     *options
+	start time.Time
+	end   time.Time
+
+	connected  atomic.Int64
+	connFailed atomic.Int64
+	connLost   atomic.Int64
+	msgs       atomic.Int64 // pub: 已发送 (QoS>0 为已确认)；sub: 已接收
+	bytes      atomic.Int64
+	errors     atomic.Int64
+	firstMsg   atomic.Int64 // UnixNano
+	lastMsg    atomic.Int64
+
+	latency     *histogram // sub: 端到端延迟；pub: QoS>0 确认延迟
+	connLatency *histogram
+
+	mu      sync.Mutex
+	clients []mqtt.Client
+}
+
+func newBench(o *options) *bench {
+	return &bench{o: o, latency: newHistogram(), connLatency: newHistogram()}
+}
+
+func (b *bench) newClient(n int) mqtt.Client {
+	o := b.o
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(o.brokerURL(n))
+	opts.SetClientID(o.clientID(n))
+	opts.SetCleanSession(o.clean)
+	opts.SetProtocolVersion(uint(o.version))
+	opts.SetKeepAlive(time.Duration(o.keepAlive) * time.Second)
+	opts.SetConnectTimeout(10 * time.Second)
+	opts.SetAutoReconnect(false)
+	if o.username != "" {
+		opts.SetUsername(o.username)
+		opts.SetPassword(o.password)
+	}
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		b.connLost.Add(1)
+	})
+	return mqtt.NewClient(opts)
+}
+
+func (b *bench) countMsg(size int) {
+	now := time.Now().UnixNano()
+	b.firstMsg.CompareAndSwap(0, now)
+	b.lastMsg.Store(now)
+	b.msgs.Add(1)
+	b.bytes.Add(int64(size))
+}
+
+func (b *bench) run(ctx context.Context) {
+	b.start = time.Now()
+	defer func() { b.end = time.Now() }()
+
+	progressDone := make(chan struct{})
+	go b.progress(ctx, progressDone)
+	defer close(progressDone)
+
+	var pubs sync.WaitGroup
+	for n := 1; n <= b.o.count; n++ {
+		if ctx.Err() != nil {
+			break
+		}
+		c := b.newClient(n)
+		t0 := time.Now()
+		tok := c.Connect()
+		if !tok.WaitTimeout(10*time.Second) || tok.Error() != nil {
+			b.connFailed.Add(1)
+		} else {
+			b.connLatency.record(time.Since(t0))
+			b.connected.Add(1)
+			b.mu.Lock()
+			b.clients = append(b.clients, c)
+			b.mu.Unlock()
+
+			switch b.o.scenario {
+			case "sub":
+				b.subscribe(c, n)
+			case "pub":
+				pubs.Add(1)
+				go func(n int) {
+					defer pubs.Done()
+					b.publishLoop(ctx, c, n)
+				}(n)
+			}
+		}
+		if b.o.connInterval > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(b.o.connInterval):
+			}
+		}
+	}
+
+	// pub 设置了 -L 时，所有发布者完成即结束
+	if b.o.scenario == "pub" && b.o.limit > 0 {
+		done := make(chan struct{})
+		go func() { pubs.Wait(); close(done) }()
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+	} else {
+		<-ctx.Done()
+	}
+	pubs.Wait()
+
+	b.mu.Lock()
+	clients := b.clients
+	b.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c mqtt.Client) {
+			defer wg.Done()
+			c.Disconnect(250)
+		}(c)
+	}
+	wg.Wait()
+}
+
+func (b *bench) subscribe(c mqtt.Client, n int) {
+	tok := c.Subscribe(b.o.topicFor(n), byte(b.o.qos), func(client mqtt.Client, msg mqtt.Message) {
+		p := msg.Payload()
+		if sent, ok := payloadSentAt(p); ok {
+			b.latency.record(time.Since(sent))
+		}
+		b.countMsg(len(p))
+	})
+	if !tok.WaitTimeout(10*time.Second) || tok.Error() != nil {
+		b.errors.Add(1)
+	}
+}
+
+func (b *bench) publishLoop(ctx context.Context, c mqtt.Client, n int) {
+	topic := b.o.topicFor(n)
+	ticker := time.NewTicker(max(b.o.pubInterval, time.Millisecond))
+	defer ticker.Stop()
+	for sent := 0; b.o.limit == 0 || sent < b.o.limit; sent++ {
+		payload := make([]byte, b.o.size)
+		t0 := time.Now()
+		stampPayload(payload, t0)
+		tok := c.Publish(topic, byte(b.o.qos), false, payload)
+		if b.o.qos > 0 {
+			if !tok.WaitTimeout(30*time.Second) || tok.Error() != nil {
+				b.errors.Add(1)
+			} else {
+				b.latency.record(time.Since(t0))
+				b.countMsg(len(payload))
+			}
+		} else {
+			b.countMsg(len(payload))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// progress 每秒输出一行进度，格式与 emqtt_bench 类似。
+func (b *bench) progress(ctx context.Context, done <-chan struct{}) {
+	if b.o.quiet {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var last int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+			m := b.msgs.Load()
+			fmt.Printf("[%s] %4.0fs conns=%d failed=%d lost=%d msgs=%d rate=%d msg/s\n",
+				b.o.scenario, time.Since(b.start).Seconds(), b.connected.Load(), b.connFailed.Load(),
+				b.connLost.Load(), m, m-last)
+			last = m
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// axmq-bench 是 AXMQ 的原生 Go 压测工具，用于替代 emqtt_bench。
+//
+// 用法:
+//
+//	axmq-bench pub  -h 10.0.0.1,10.0.0.2 -p 1883 -c 100 -t "bench/%i" -q 0 -s 256 -I 10
+//	axmq-bench sub  -h 10.0.0.1 -p 1883 -c 10 -t "bench/+" -q 0
+//	axmq-bench conn -h 10.0.0.1 -p 1883 -c 1000 -i 10 -k 60
+//
+// 主题模板中 %i 替换为客户端序号 (从 1 开始，与 emqtt_bench 一致)，%c 替换为客户端 ID。-h 指定多个节点时客户端按序号轮流分布。
+// 收到 SIGINT/SIGTERM (如被 timeout 结束) 或到达 -d 时长后输出汇总报告。
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type options struct {
+	scenario     string
+	hosts        []string
+	port         int
+	version      int
+	count        int
+	connInterval time.Duration
+	pubInterval  time.Duration
+	topic        string
+	qos          int
+	size         int
+	keepAlive    int
+	limit        int
+	duration     time.Duration
+	clean        bool
+	prefix       string
+	username     string
+	password     string
+	jsonPath     string
+	quiet        bool
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: axmq-bench pub|sub|conn [flags]")
+	fmt.Fprintln(os.Stderr, "run 'axmq-bench <scenario> -help' for scenario flags")
+}
+
+func parseOptions(args []string) (*options, error) {
+	if len(args) == 0 {
+		usage()
+		return nil, fmt.Errorf("missing scenario")
+	}
+	o := &options{scenario: args[0]}
+	switch o.scenario {
+	case "pub", "sub", "conn":
+	default:
+		usage()
+		return nil, fmt.Errorf("unknown scenario %q", o.scenario)
+	}
+
+	fs := flag.NewFlagSet(o.scenario, flag.ContinueOnError)
+	hosts := fs.String("h", "localhost", "broker hosts, comma separated; tcp:// or ws:// URLs are used as is")
+	fs.IntVar(&o.port, "p", 1883, "broker port for hosts without scheme")
+	fs.IntVar(&o.version, "V", 4, "MQTT protocol version (3 or 4)")
+	fs.IntVar(&o.count, "c", 1, "number of clients")
+	connMs := fs.Int("i", 10, "interval between client connects (ms)")
+	pubMs := fs.Int("I", 10, "publish interval per client (ms)")
+	fs.StringVar(&o.topic, "t", "bench/%i", "topic (pub/sub), supports %i and %c")
+	fs.IntVar(&o.qos, "q", 0, "QoS level")
+	fs.IntVar(&o.size, "s", 256, "payload size in bytes (pub)")
+	fs.IntVar(&o.keepAlive, "k", 60, "keep alive (s)")
+	fs.IntVar(&o.limit, "L", 0, "messages per publisher, 0 means unlimited (pub)")
+	fs.DurationVar(&o.duration, "d", 0, "stop after this duration, 0 means run until interrupted")
+	fs.BoolVar(&o.clean, "C", true, "clean session")
+	fs.StringVar(&o.prefix, "prefix", "", "client id prefix (default axmq_bench_<scenario>_<pid>_)")
+	fs.StringVar(&o.username, "u", "", "username")
+	fs.StringVar(&o.password, "P", "", "password")
+	fs.StringVar(&o.jsonPath, "json", "", "write the final report as JSON to this path ('-' for stdout)")
+	fs.BoolVar(&o.quiet, "quiet", false, "suppress per-second progress lines")
+	if err := fs.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+
+	for _, h := range strings.Split(*hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			o.hosts = append(o.hosts, h)
+		}
+	}
+	if len(o.hosts) == 0 {
+		return nil, fmt.Errorf("no hosts")
+	}
+	if o.count <= 0 || o.qos < 0 || o.qos > 2 || o.size < 0 {
+		return nil, fmt.Errorf("invalid -c/-q/-s")
+	}
+	if o.version != 3 && o.version != 4 {
+		return nil, fmt.Errorf("unsupported protocol version %d", o.version)
+	}
+	o.connInterval = time.Duration(*connMs) * time.Millisecond
+	o.pubInterval = time.Duration(*pubMs) * time.Millisecond
+	if o.prefix == "" {
+		o.prefix = "axmq_bench_" + o.scenario + "_" + strconv.Itoa(os.Getpid()) + "_"
+	}
+	return o, nil
+}
+
+// brokerURL 返回序号为 n 的客户端使用的地址，多个节点时按序号轮流分布。
+func (o *options) brokerURL(n int) string {
+	h := o.hosts[(n-1)%len(o.hosts)]
+	if strings.Contains(h, "://") {
+		return h
+	}
+	return "tcp://" + h + ":" + strconv.Itoa(o.port)
+}
+
+func (o *options) clientID(n int) string {
+	return o.prefix + strconv.Itoa(n)
+}
+
+func (o *options) topicFor(n int) string {
+	t := strings.ReplaceAll(o.topic, "%i", strconv.Itoa(n))
+	return strings.ReplaceAll(t, "%c", o.clientID(n))
+}
+
+func main() {
+	o, err := parseOptions(os.Args[1:])
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, "axmq-bench:", err)
+		}
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if o.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.duration)
+		defer cancel()
+	}
+
+	b := newBench(o)
+	b.run(ctx)
+
+	rep := b.report()
+	fmt.Print(rep.text())
+	if o.jsonPath != "" {
+		if err := rep.writeJSON(o.jsonPath); err != nil {
+			fmt.Fprintln(os.Stderr, "axmq-bench: write json:", err)
+			os.Exit(1)
+		}
+	}
+}
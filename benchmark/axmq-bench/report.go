@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// histogram 是 HDR 风格的对数-线性直方图，以微秒为单位，每个 2 的幂区间分 128 个桶，
+// 相对误差小于 1%，内存占用固定，适合记录百万级样本。
+type histogram struct {
+	mu     sync.Mutex
+	counts [64 * subBuckets]uint64
+	total  uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+const (
+	subBucketBits = 7
+	subBuckets    = 1 << subBucketBits
+)
+
+func newHistogram() *histogram { return &histogram{} }
+
+func bucketIndex(us uint64) int {
+	if us < subBuckets {
+		return int(us)
+	}
+	shift := bits.Len64(us) - subBucketBits - 1
+	return subBuckets + shift*subBuckets + int(us>>shift) - subBuckets
+}
+
+// bucketValue 返回桶的下界 (微秒)。
+func bucketValue(i int) uint64 {
+	if i < subBuckets {
+		return uint64(i)
+	}
+	shift := (i - subBuckets) / subBuckets
+	return uint64((i-subBuckets)%subBuckets+subBuckets) << shift
+}
+
+func (h *histogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.mu.Lock()
+	h.counts[bucketIndex(uint64(d/time.Microsecond))]++
+	h.total++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+	h.mu.Unlock()
+}
+
+// quantile 返回 q (0..1) 分位的近似值。
+func (h *histogram) quantile(q float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(h.total) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			return time.Duration(bucketValue(i)) * time.Microsecond
+		}
+	}
+	return h.max
+}
+
+type latencySummary struct {
+	Count  uint64  `json:"count"`
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P90Ms  float64 `json:"p90_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	P999Ms float64 `json:"p999_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+func (h *histogram) summary() *latencySummary {
+	h.mu.Lock()
+	total, sum, maxD := h.total, h.sum, h.max
+	h.mu.Unlock()
+	if total == 0 {
+		return nil
+	}
+	return &latencySummary{
+		Count:  total,
+		MeanMs: ms(sum / time.Duration(total)),
+		P50Ms:  ms(h.quantile(0.50)),
+		P90Ms:  ms(h.quantile(0.90)),
+		P99Ms:  ms(h.quantile(0.99)),
+		P999Ms: ms(h.quantile(0.999)),
+		MaxMs:  ms(maxD),
+	}
+}
+
+type report struct {
+	Scenario       string          `json:"scenario"`
+	Hosts          []string        `json:"hosts"`
+	Clients        int             `json:"clients"`
+	QoS            int             `json:"qos"`
+	PayloadSize    int             `json:"payload_size,omitempty"`
+	Topic          string          `json:"topic,omitempty"`
+	DurationSec    float64         `json:"duration_sec"`
+	Connected      int64           `json:"connected"`
+	ConnectFailed  int64           `json:"connect_failed"`
+	ConnectionLost int64           `json:"connection_lost"`
+	Messages       int64           `json:"messages"`
+	Bytes          int64           `json:"bytes"`
+	MsgsPerSec     float64         `json:"msgs_per_sec"`
+	BytesPerSec    float64         `json:"bytes_per_sec"`
+	Errors         int64           `json:"errors"`
+	Latency        *latencySummary `json:"latency,omitempty"`
+	ConnectLatency *latencySummary `json:"connect_latency,omitempty"`
+}
+
+func (b *bench) report() *report {
+	r := &report{
+		Scenario:       b.o.scenario,
+		Hosts:          b.o.hosts,
+		Clients:        b.o.count,
+		QoS:            b.o.qos,
+		DurationSec:    b.end.Sub(b.start).Seconds(),
+		Connected:      b.connected.Load(),
+		ConnectFailed:  b.connFailed.Load(),
+		ConnectionLost: b.connLost.Load(),
+		Messages:       b.msgs.Load(),
+		Bytes:          b.bytes.Load(),
+		Errors:         b.errors.Load(),
+		Latency:        b.latency.summary(),
+		ConnectLatency: b.connLatency.summary(),
+	}
+	if b.o.scenario != "conn" {
+		r.Topic = b.o.topic
+	}
+	if b.o.scenario == "pub" {
+		r.PayloadSize = b.o.size
+	}
+	// 速率按首条到末条消息之间的活跃窗口计算，不含建连阶段
+	if first, last := b.firstMsg.Load(), b.lastMsg.Load(); r.Messages > 1 && last > first {
+		secs := time.Duration(last - first).Seconds()
+		r.MsgsPerSec = float64(r.Messages-1) / secs
+		r.BytesPerSec = float64(r.Bytes) * (float64(r.Messages-1) / float64(r.Messages)) / secs
+	}
+	return r
+}
+
+func (r *report) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "==== axmq-bench %s summary ====\n", r.Scenario)
+	fmt.Fprintf(&b, "hosts:       %s\n", strings.Join(r.Hosts, ","))
+	fmt.Fprintf(&b, "duration:    %.1fs\n", r.DurationSec)
+	fmt.Fprintf(&b, "clients:     %d connected, %d failed, %d lost (of %d)\n", r.Connected, r.ConnectFailed, r.ConnectionLost, r.Clients)
+	if r.Scenario != "conn" {
+		fmt.Fprintf(&b, "messages:    %d (%d bytes), errors %d\n", r.Messages, r.Bytes, r.Errors)
+		fmt.Fprintf(&b, "throughput:  %.1f msg/s, %.1f MB/s\n", r.MsgsPerSec, r.BytesPerSec/1e6)
+	}
+	writeLat := func(name string, l *latencySummary) {
+		if l == nil {
+			return
+		}
+		fmt.Fprintf(&b, "%-12s n=%d mean=%.2fms p50=%.2fms p90=%.2fms p99=%.2fms p999=%.2fms max=%.2fms\n",
+			name+":", l.Count, l.MeanMs, l.P50Ms, l.P90Ms, l.P99Ms, l.P999Ms, l.MaxMs)
+	}
+	switch r.Scenario {
+	case "sub":
+		writeLat("e2e latency", r.Latency)
+	case "pub":
+		writeLat("ack latency", r.Latency)
+	}
+	writeLat("connect", r.ConnectLatency)
+	return b.String()
+}
+
+func (r *report) writeJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -1,6 +1,6 @@
 #!/bin/bash
 # ApexMQTT 集群性能测试脚本
-# 使用 emqtt-bench 进行专业压测
+# 使用 axmq-bench (Go 原生压测工具) 进行压测
 # 每个测试场景限制在 10 秒内完成
 # 使用 MQTT 3.1.1 协议 (-V 4)
 # 参考: AXMQ-Flash/readme.md
@@ -20,8 +20,8 @@ PAYLOAD_SIZE=256        # 消息体大小 (bytes)
 TEST_DURATION=10        # 每个测试持续时间 (秒)
 PUB_INTERVAL=10         # 发布间隔 (ms)
 
-# emqtt_bench 路径（在 main 中初始化）
-EMQTT_BENCH=""
+# axmq-bench 路径（在 main 中初始化）
+AXMQ_BENCH=""
 
 # 颜色输出
 RED='\033[0;31m'
@@ -36,7 +36,7 @@ log_warn() { echo -e "${YELLOW}[WARN]${NC} $1"; }
 log_error() { echo -e "${RED}[FAIL]${NC} $1"; }
 
 cleanup() {
-    pkill -f "emqtt_bench" 2>/dev/null || true
+    pkill -f "axmq-bench" 2>/dev/null || true
 }
 trap cleanup EXIT
 
@@ -49,13 +49,13 @@ test_single_node_baseline() {
     log_info "配置: 10 订阅者 + 100 发布者，同一节点"
     
     # 启动订阅者
-    timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 10 -t "bench/single" -q 0 &
+    timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 10 -t "bench/single" -q 0 &
     SUB_PID=$!
     sleep 2
     
     # 启动发布者
     log_info "发布中..."
-    timeout $((TEST_DURATION - 3))s $EMQTT_BENCH pub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 100 -t "bench/single" -q 0 \
+    timeout $((TEST_DURATION - 3))s $AXMQ_BENCH pub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 100 -t "bench/single" -q 0 \
         -s $PAYLOAD_SIZE -I $PUB_INTERVAL || true
     
     wait $SUB_PID 2>/dev/null || true
@@ -72,13 +72,13 @@ test_cross_node_forwarding() {
     log_info "配置: 订阅者在 Node1, 发布者在 Node2"
     
     # 启动订阅者 (Node1)
-    timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 10 -t "bench/cross" -q 0 &
+    timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 10 -t "bench/cross" -q 0 &
     SUB_PID=$!
     sleep 3  # 等待订阅同步到集群
     
     # 启动发布者 (Node2)
     log_info "发布中..."
-    timeout $((TEST_DURATION - 4))s $EMQTT_BENCH pub -V 4 -h $NODE2_HOST -p $MQTT_PORT -c 100 -t "bench/cross" -q 0 \
+    timeout $((TEST_DURATION - 4))s $AXMQ_BENCH pub -V 4 -h $NODE2_HOST -p $MQTT_PORT -c 100 -t "bench/cross" -q 0 \
         -s $PAYLOAD_SIZE -I $PUB_INTERVAL || true
     
     wait $SUB_PID 2>/dev/null || true
@@ -95,17 +95,17 @@ test_multi_node_subscribers() {
     log_info "配置: 每个节点 10 订阅者, 发布者在 Node1"
     
     # 在三个节点启动订阅者
-    timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 10 -t "bench/fanout" -q 0 &
+    timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 10 -t "bench/fanout" -q 0 &
     SUB_PID1=$!
-    timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE2_HOST -p $MQTT_PORT -c 10 -t "bench/fanout" -q 0 &
+    timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE2_HOST -p $MQTT_PORT -c 10 -t "bench/fanout" -q 0 &
     SUB_PID2=$!
-    timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE3_HOST -p $MQTT_PORT -c 10 -t "bench/fanout" -q 0 &
+    timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE3_HOST -p $MQTT_PORT -c 10 -t "bench/fanout" -q 0 &
     SUB_PID3=$!
     sleep 3
     
     # 启动发布者
     log_info "发布中..."
-    timeout $((TEST_DURATION - 4))s $EMQTT_BENCH pub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 50 -t "bench/fanout" -q 0 \
+    timeout $((TEST_DURATION - 4))s $AXMQ_BENCH pub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 50 -t "bench/fanout" -q 0 \
         -s $PAYLOAD_SIZE -I $PUB_INTERVAL || true
     
     wait $SUB_PID1 $SUB_PID2 $SUB_PID3 2>/dev/null || true
@@ -122,13 +122,13 @@ test_qos1_cross_node() {
     log_info "配置: 订阅者在 Node1 (QoS 1), 发布者在 Node2 (QoS 1)"
     
     # 启动订阅者
-    timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 10 -t "bench/qos1" -q 1 &
+    timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 10 -t "bench/qos1" -q 1 &
     SUB_PID=$!
     sleep 3
     
     # 启动发布者 (QoS 1，间隔稍大)
     log_info "发布中..."
-    timeout $((TEST_DURATION - 4))s $EMQTT_BENCH pub -V 4 -h $NODE2_HOST -p $MQTT_PORT -c 50 -t "bench/qos1" -q 1 \
+    timeout $((TEST_DURATION - 4))s $AXMQ_BENCH pub -V 4 -h $NODE2_HOST -p $MQTT_PORT -c 50 -t "bench/qos1" -q 1 \
         -s $PAYLOAD_SIZE -I 16 || true
     
     wait $SUB_PID 2>/dev/null || true
@@ -145,13 +145,13 @@ test_qos2_cross_node() {
     log_info "配置: 订阅者在 Node1 (QoS 2), 发布者在 Node2 (QoS 2)"
     
     # 启动订阅者
-    timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 10 -t "bench/qos2" -q 2 &
+    timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 10 -t "bench/qos2" -q 2 &
     SUB_PID=$!
     sleep 3
     
     # 启动发布者 (QoS 2 需要四次握手，间隔更大，客户端更少)
     log_info "发布中..."
-    timeout $((TEST_DURATION - 4))s $EMQTT_BENCH pub -V 4 -h $NODE2_HOST -p $MQTT_PORT -c 20 -t "bench/qos2" -q 2 \
+    timeout $((TEST_DURATION - 4))s $AXMQ_BENCH pub -V 4 -h $NODE2_HOST -p $MQTT_PORT -c 20 -t "bench/qos2" -q 2 \
         -s $PAYLOAD_SIZE -I 20 || true
     
     wait $SUB_PID 2>/dev/null || true
@@ -168,13 +168,13 @@ test_wildcard_cross_node() {
     log_info "配置: Node1 订阅 sensors/+/data, Node2 发布到 sensors/{id}/data"
     
     # 启动通配符订阅者
-    timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 10 -t "sensors/+/data" -q 0 &
+    timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 10 -t "sensors/+/data" -q 0 &
     SUB_PID=$!
     sleep 3
     
     # 启动发布者 (使用 %i 模板发布到不同主题)
     log_info "发布中..."
-    timeout $((TEST_DURATION - 4))s $EMQTT_BENCH pub -V 4 -h $NODE2_HOST -p $MQTT_PORT -c 100 \
+    timeout $((TEST_DURATION - 4))s $AXMQ_BENCH pub -V 4 -h $NODE2_HOST -p $MQTT_PORT -c 100 \
         -t "sensors/%i/data" -q 0 -s $PAYLOAD_SIZE -I $PUB_INTERVAL || true
     
     wait $SUB_PID 2>/dev/null || true
@@ -191,11 +191,11 @@ test_high_concurrency() {
     log_info "配置: 每个节点建立 200 个连接"
     
     # 使用 conn 子命令测试连接
-    timeout ${TEST_DURATION}s $EMQTT_BENCH conn -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 200 -i 10 -k 60 &
+    timeout ${TEST_DURATION}s $AXMQ_BENCH conn -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 200 -i 10 -k 60 &
     CONN_PID1=$!
-    timeout ${TEST_DURATION}s $EMQTT_BENCH conn -V 4 -h $NODE2_HOST -p $MQTT_PORT -c 200 -i 10 -k 60 &
+    timeout ${TEST_DURATION}s $AXMQ_BENCH conn -V 4 -h $NODE2_HOST -p $MQTT_PORT -c 200 -i 10 -k 60 &
     CONN_PID2=$!
-    timeout ${TEST_DURATION}s $EMQTT_BENCH conn -V 4 -h $NODE3_HOST -p $MQTT_PORT -c 200 -i 10 -k 60 &
+    timeout ${TEST_DURATION}s $AXMQ_BENCH conn -V 4 -h $NODE3_HOST -p $MQTT_PORT -c 200 -i 10 -k 60 &
     CONN_PID3=$!
     
     wait $CONN_PID1 $CONN_PID2 $CONN_PID3 2>/dev/null || true
@@ -212,17 +212,17 @@ test_n2n() {
     log_info "配置: 100 订阅者对 100 发布者，每对使用唯一主题 bench/nn/%i"
     
     # 三个节点启动订阅者
-    timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 33 -t "bench/nn/%i" -q 0 &
+    timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 33 -t "bench/nn/%i" -q 0 &
     SUB_PID1=$!
-    timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE2_HOST -p $MQTT_PORT -c 33 -t "bench/nn/%i" -q 0 &
+    timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE2_HOST -p $MQTT_PORT -c 33 -t "bench/nn/%i" -q 0 &
     SUB_PID2=$!
-    timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE3_HOST -p $MQTT_PORT -c 34 -t "bench/nn/%i" -q 0 &
+    timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE3_HOST -p $MQTT_PORT -c 34 -t "bench/nn/%i" -q 0 &
     SUB_PID3=$!
     sleep 3
     
     # 启动发布者
     log_info "发布中..."
-    timeout $((TEST_DURATION - 4))s $EMQTT_BENCH pub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 100 \
+    timeout $((TEST_DURATION - 4))s $AXMQ_BENCH pub -V 4 -h $NODE1_HOST -p $MQTT_PORT -c 100 \
         -t "bench/nn/%i" -q 0 -s $PAYLOAD_SIZE -I $PUB_INTERVAL || true
     
     wait $SUB_PID1 $SUB_PID2 $SUB_PID3 2>/dev/null || true
@@ -236,7 +236,7 @@ test_n2n() {
 main() {
     echo ""
     echo "============================================================"
-    echo "       ApexMQTT 集群性能测试 (emqtt-bench)"
+    echo "       ApexMQTT 集群性能测试 (axmq-bench)"
     echo "       MQTT 3.1.1 协议 | 每个测试限时 ${TEST_DURATION}s"
     echo "============================================================"
     echo ""
@@ -250,22 +250,26 @@ main() {
     echo "  Publish Interval: ${PUB_INTERVAL}ms"
     echo ""
     
-    # 检查 emqtt_bench（必须在解压目录运行）
-    if [ -x "./bin/emqtt_bench" ]; then
-        EMQTT_BENCH="./bin/emqtt_bench"
-    elif [ -x "$HOME/bin/emqtt_bench" ]; then
-        EMQTT_BENCH="$HOME/bin/emqtt_bench"
-    else
-        log_error "emqtt_bench 未找到，请在解压目录运行此脚本！"
+    # 检查 axmq-bench (Go 原生压测工具，源码位于 benchmark/axmq-bench)
+    # 构建: cd benchmark/axmq-bench && go build (生成 benchmark/axmq-bench/axmq-bench)
+    AXMQ_BENCH="${AXMQ_BENCH:-}"
+    if [ -z "$AXMQ_BENCH" ]; then
+        if [ -f "./axmq-bench/axmq-bench" ] && [ -x "./axmq-bench/axmq-bench" ]; then
+            AXMQ_BENCH="./axmq-bench/axmq-bench"
+        elif command -v axmq-bench &> /dev/null; then
+            AXMQ_BENCH="$(command -v axmq-bench)"
+        fi
+    fi
+    if [ -z "$AXMQ_BENCH" ]; then
+        log_error "axmq-bench 未找到"
         echo ""
-        echo "安装步骤 (Ubuntu 24.04 使用 debian12 版本):"
-        echo "  cd ~"
-        echo "  wget https://github.com/emqx/emqtt-bench/releases/download/0.6.1/emqtt-bench-0.6.1-debian12-amd64.tar.gz"
-        echo "  tar -xzf emqtt-bench-0.6.1-debian12-amd64.tar.gz"
+        echo "构建步骤:"
+        echo "  cd benchmark/axmq-bench && go build && cd .."
         echo "  ./cluster_benchmark.sh"
+        echo "或设置 AXMQ_BENCH=/path/to/axmq-bench"
         exit 1
     fi
-    log_info "使用: $EMQTT_BENCH"
+    log_info "使用: $AXMQ_BENCH"
     echo ""
     
     case "${1:-all}" in
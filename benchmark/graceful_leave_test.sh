@@ -1,7 +1,8 @@
 #!/bin/bash
 # ApexMQTT 集群优雅下线功能验证
-# 使用 emqtt-bench 测试 Session 迁移
+# 使用 axmq-bench 测试 Session 迁移
 # 参考: tests/benchmark/quick_test.sh
+# 自动化版本 (本地集群 + 断言): go test -v -run TestMQTT_Cluster_GracefulLeave mqtt_*_test.go
 
 set -e
 
@@ -31,37 +32,25 @@ echo ""
 echo "节点: Node1=$NODE1, Node2=$NODE2, Node3=$NODE3"
 echo ""
 
-# 检查 emqtt_bench
-EMQTT_BENCH=""
-if [ -x "./bin/emqtt_bench" ]; then
-    EMQTT_BENCH="./bin/emqtt_bench"
-elif [ -x "$HOME/bin/emqtt_bench" ]; then
-    EMQTT_BENCH="$HOME/bin/emqtt_bench"
-else
-    log_fail "emqtt_bench 未找到，请在 emqtt-bench 解压目录运行此脚本"
-    exit 1
-fi
-log_info "使用: $EMQTT_BENCH"
-
-# 检查 emqtt-bench 是否支持 CleanSession 参数
-# 不同版本参数可能是 -C, --clean, --clean-session 等
-CLEAN_SESSION_PARAM=""
-if $EMQTT_BENCH sub --help 2>&1 | grep -q "clean-session"; then
-    CLEAN_SESSION_PARAM="--clean-session false"
-elif $EMQTT_BENCH sub --help 2>&1 | grep -q "\-C"; then
-    CLEAN_SESSION_PARAM="-C false"
+# 检查 axmq-bench (Go 原生压测工具，源码位于 benchmark/axmq-bench)
+# 构建: cd benchmark/axmq-bench && go build (生成 benchmark/axmq-bench/axmq-bench)
+AXMQ_BENCH="${AXMQ_BENCH:-}"
+if [ -z "$AXMQ_BENCH" ]; then
+    if [ -f "./axmq-bench/axmq-bench" ] && [ -x "./axmq-bench/axmq-bench" ]; then
+        AXMQ_BENCH="./axmq-bench/axmq-bench"
+    elif command -v axmq-bench &> /dev/null; then
+        AXMQ_BENCH="$(command -v axmq-bench)"
+    fi
 fi
-
-if [ -n "$CLEAN_SESSION_PARAM" ]; then
-    log_info "CleanSession 参数: $CLEAN_SESSION_PARAM"
-else
-    log_info "注意: emqtt-bench 可能不支持设置 CleanSession=false"
-    log_info "将使用日志观察方式验证优雅下线"
+if [ -z "$AXMQ_BENCH" ]; then
+    log_fail "axmq-bench 未找到，请先构建: cd benchmark/axmq-bench && go build"
+    exit 1
 fi
+log_info "使用: $AXMQ_BENCH"
 echo ""
 
 cleanup() {
-    pkill -f "emqtt_bench" 2>/dev/null || true
+    pkill -f "axmq-bench" 2>/dev/null || true
 }
 trap cleanup EXIT
 
@@ -77,31 +66,22 @@ log_info "ClientID 前缀: graceful_test_"
 log_info "订阅主题: graceful/test/topic"
 echo ""
 
-# 启动订阅者（尝试使用持久 Session）
+# 启动订阅者（持久 Session）
 # 使用 QoS 1 确保有消息状态
-if [ -n "$CLEAN_SESSION_PARAM" ]; then
-    $EMQTT_BENCH sub -V 4 -h $NODE1 -p $PORT \
-        -c 10 \
-        -t "graceful/test/topic" \
-        -q 1 \
-        --prefix "graceful_test_" \
-        $CLEAN_SESSION_PARAM \
-        &
-else
-    $EMQTT_BENCH sub -V 4 -h $NODE1 -p $PORT \
-        -c 10 \
-        -t "graceful/test/topic" \
-        -q 1 \
-        --prefix "graceful_test_" \
-        &
-fi
+$AXMQ_BENCH sub -V 4 -h $NODE1 -p $PORT \
+    -c 10 \
+    -t "graceful/test/topic" \
+    -q 1 \
+    --prefix "graceful_test_" \
+    -C=false \
+    &
 SUB_PID=$!
 
 sleep 3
 
 # 发送一些测试消息，确保订阅生效
 log_info "发送测试消息验证订阅..."
-$EMQTT_BENCH pub -V 4 -h $NODE2 -p $PORT \
+$AXMQ_BENCH pub -V 4 -h $NODE2 -p $PORT \
     -c 1 \
     -t "graceful/test/topic" \
     -q 1 \
@@ -163,29 +143,20 @@ echo ""
 
 # 重新启动订阅者（使用相同前缀）
 # 如果 Session 迁移成功，应该能立即接收消息
-if [ -n "$CLEAN_SESSION_PARAM" ]; then
-    $EMQTT_BENCH sub -V 4 -h $NODE2 -p $PORT \
-        -c 10 \
-        -t "graceful/test/topic" \
-        -q 1 \
-        --prefix "graceful_test_" \
-        $CLEAN_SESSION_PARAM \
-        &
-else
-    $EMQTT_BENCH sub -V 4 -h $NODE2 -p $PORT \
-        -c 10 \
-        -t "graceful/test/topic" \
-        -q 1 \
-        --prefix "graceful_test_" \
-        &
-fi
+$AXMQ_BENCH sub -V 4 -h $NODE2 -p $PORT \
+    -c 10 \
+    -t "graceful/test/topic" \
+    -q 1 \
+    --prefix "graceful_test_" \
+    -C=false \
+    &
 SUB_PID=$!
 
 sleep 3
 
 # 发送新消息测试
 log_info "发送新消息验证 Session 恢复..."
-$EMQTT_BENCH pub -V 4 -h $NODE2 -p $PORT \
+$AXMQ_BENCH pub -V 4 -h $NODE2 -p $PORT \
     -c 1 \
     -t "graceful/test/topic" \
     -q 1 \
@@ -228,5 +199,5 @@ echo ""
 echo "  1. 检查 Node1 是否正常执行了 GracefulLeave"
 echo "  2. 检查 Node2/Node3 是否收到 Session 推送"
 echo "  3. 检查 ClientID 前缀是否一致"
-echo "  4. 确保使用了 CleanSession=false (-C false)"
+echo "  4. 确保使用了 CleanSession=false (-C=false)"
 echo ""
@@ -2,6 +2,7 @@
 # ApexMQTT 持久会话自动重订阅功能验证
 # MQTT 3.1.1 规范: CleanSession=false 时，订阅应跨重连持久化
 # 参考: mqtt_functional_test.go
+# Go 版本: mqtt_persistent_session_test.go (TCP_Only/Persistent_Session 与 TestMQTT_Cluster/Persistent_Session_CrossNode)
 
 set -e
 
@@ -23,28 +23,29 @@ echo ""
 echo "节点: Node1=$NODE1, Node2=$NODE2, Node3=$NODE3"
 echo ""
 
-# 检查 emqtt_bench（优先使用本地 bin/ 目录）
-EMQTT_BENCH=""
-if [ -x "./bin/emqtt_bench" ]; then
-    EMQTT_BENCH="./bin/emqtt_bench"
-elif [ -x "$HOME/bin/emqtt_bench" ]; then
-    EMQTT_BENCH="$HOME/bin/emqtt_bench"
-else
-    echo "[ERROR] emqtt_bench 未找到"
-    echo ""
-    echo "请确保在 emqtt-bench 解压目录运行此脚本！"
+# 检查 axmq-bench (Go 原生压测工具，源码位于 benchmark/axmq-bench)
+# 构建: cd benchmark/axmq-bench && go build (生成 benchmark/axmq-bench/axmq-bench)
+AXMQ_BENCH="${AXMQ_BENCH:-}"
+if [ -z "$AXMQ_BENCH" ]; then
+    if [ -f "./axmq-bench/axmq-bench" ] && [ -x "./axmq-bench/axmq-bench" ]; then
+        AXMQ_BENCH="./axmq-bench/axmq-bench"
+    elif command -v axmq-bench &> /dev/null; then
+        AXMQ_BENCH="$(command -v axmq-bench)"
+    fi
+fi
+if [ -z "$AXMQ_BENCH" ]; then
+    echo "[ERROR] axmq-bench 未找到"
     echo ""
-    echo "安装步骤 (Ubuntu 24.04 使用 debian12 版本):"
-    echo "  cd ~"
-    echo "  wget https://github.com/emqx/emqtt-bench/releases/download/0.6.1/emqtt-bench-0.6.1-debian12-amd64.tar.gz"
-    echo "  tar -xzf emqtt-bench-0.6.1-debian12-amd64.tar.gz"
+    echo "构建步骤:"
+    echo "  cd benchmark/axmq-bench && go build && cd .."
     echo "  ./quick_test.sh"
+    echo "或设置 AXMQ_BENCH=/path/to/axmq-bench"
     exit 1
 fi
-echo "使用: $EMQTT_BENCH"
+echo "使用: $AXMQ_BENCH"
 
 cleanup() {
-    pkill -f "emqtt_bench" 2>/dev/null || true
+    pkill -f "axmq-bench" 2>/dev/null || true
 }
 trap cleanup EXIT
 
@@ -59,13 +60,13 @@ echo "  发布者: Node2 x 100"
 echo "----------------------------------------"
 
 # 启动订阅者 (Node1)
-timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE1 -p $PORT -c 10 -t "bench/1" -q 0 &
+timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE1 -p $PORT -c 10 -t "bench/1" -q 0 &
 SUB_PID=$!
 sleep 2
 
 # 启动发布者 (Node2)
 echo "[发布中... ${TEST_DURATION}s]"
-timeout $((TEST_DURATION - 3))s $EMQTT_BENCH pub -V 4 -h $NODE2 -p $PORT -c 100 -t "bench/1" -q 0 -s 256 -I 10 || true
+timeout $((TEST_DURATION - 3))s $AXMQ_BENCH pub -V 4 -h $NODE2 -p $PORT -c 100 -t "bench/1" -q 0 -s 256 -I 10 || true
 
 wait $SUB_PID 2>/dev/null || true
 echo ""
@@ -79,12 +80,12 @@ echo "  订阅者: Node1 x 10"
 echo "  发布者: Node2 x 50"
 echo "----------------------------------------"
 
-timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE1 -p $PORT -c 10 -t "bench/qos1" -q 1 &
+timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE1 -p $PORT -c 10 -t "bench/qos1" -q 1 &
 SUB_PID=$!
 sleep 2
 
 echo "[发布中... ${TEST_DURATION}s]"
-timeout $((TEST_DURATION - 3))s $EMQTT_BENCH pub -V 4 -h $NODE2 -p $PORT -c 50 -t "bench/qos1" -q 1 -s 256 -I 16 || true
+timeout $((TEST_DURATION - 3))s $AXMQ_BENCH pub -V 4 -h $NODE2 -p $PORT -c 50 -t "bench/qos1" -q 1 -s 256 -I 16 || true
 
 wait $SUB_PID 2>/dev/null || true
 echo ""
@@ -98,13 +99,13 @@ echo "  订阅者: Node1 订阅 sensors/+/data"
 echo "  发布者: Node2 发布到 sensors/{id}/data"
 echo "----------------------------------------"
 
-timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE1 -p $PORT -c 10 -t "sensors/+/data" -q 0 &
+timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE1 -p $PORT -c 10 -t "sensors/+/data" -q 0 &
 SUB_PID=$!
 sleep 2
 
 echo "[发布中... ${TEST_DURATION}s]"
 # 使用 %i 模板让每个客户端发布到不同主题
-timeout $((TEST_DURATION - 3))s $EMQTT_BENCH pub -V 4 -h $NODE2 -p $PORT -c 100 -t "sensors/%i/data" -q 0 -s 256 -I 10 || true
+timeout $((TEST_DURATION - 3))s $AXMQ_BENCH pub -V 4 -h $NODE2 -p $PORT -c 100 -t "sensors/%i/data" -q 0 -s 256 -I 10 || true
 
 wait $SUB_PID 2>/dev/null || true
 echo ""
@@ -118,13 +119,13 @@ echo "  订阅者: Node1 x 10 (QoS 2)"
 echo "  发布者: Node2 x 20 (QoS 2)"
 echo "----------------------------------------"
 
-timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE1 -p $PORT -c 10 -t "bench/qos2" -q 2 &
+timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE1 -p $PORT -c 10 -t "bench/qos2" -q 2 &
 SUB_PID=$!
 sleep 2
 
 echo "[发布中... ${TEST_DURATION}s]"
 # QoS 2 需要四次握手，使用更大间隔和更少客户端
-timeout $((TEST_DURATION - 3))s $EMQTT_BENCH pub -V 4 -h $NODE2 -p $PORT -c 20 -t "bench/qos2" -q 2 -s 256 -I 20 || true
+timeout $((TEST_DURATION - 3))s $AXMQ_BENCH pub -V 4 -h $NODE2 -p $PORT -c 20 -t "bench/qos2" -q 2 -s 256 -I 20 || true
 
 wait $SUB_PID 2>/dev/null || true
 echo ""
@@ -139,16 +140,16 @@ echo "  发布者: Node1 x 90 (bench/nn/%i)"
 echo "----------------------------------------"
 
 # 三个节点启动订阅者
-timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE1 -p $PORT -c 30 -t "bench/nn/%i" -q 0 &
+timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE1 -p $PORT -c 30 -t "bench/nn/%i" -q 0 &
 SUB_PID1=$!
-timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE2 -p $PORT -c 30 -t "bench/nn/%i" -q 0 &
+timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE2 -p $PORT -c 30 -t "bench/nn/%i" -q 0 &
 SUB_PID2=$!
-timeout ${TEST_DURATION}s $EMQTT_BENCH sub -V 4 -h $NODE3 -p $PORT -c 30 -t "bench/nn/%i" -q 0 &
+timeout ${TEST_DURATION}s $AXMQ_BENCH sub -V 4 -h $NODE3 -p $PORT -c 30 -t "bench/nn/%i" -q 0 &
 SUB_PID3=$!
 sleep 2
 
 echo "[发布中... ${TEST_DURATION}s]"
-timeout $((TEST_DURATION - 3))s $EMQTT_BENCH pub -V 4 -h $NODE1 -p $PORT -c 90 -t "bench/nn/%i" -q 0 -s 256 -I 10 || true
+timeout $((TEST_DURATION - 3))s $AXMQ_BENCH pub -V 4 -h $NODE1 -p $PORT -c 90 -t "bench/nn/%i" -q 0 -s 256 -I 10 || true
 
 wait $SUB_PID1 $SUB_PID2 $SUB_PID3 2>/dev/null || true
 echo ""
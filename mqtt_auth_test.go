@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 认证凭据：Broker 在后台 Policies 页面启用 Basic Auth / JWT / 白名单策略时，测试客户端需要携带凭据。
+// 凭据从 MQTT_AUTH_FILE 指向的 JSON 文件读取 (字段同 credentials 的 json tag)，再由同名环境变量覆盖：
+//
+//	MQTT_USERNAME / MQTT_PASSWORD      Basic Auth 用户名和密码
+//	MQTT_JWT                           固定的 JWT (放在 password 字段)
+//	MQTT_JWT_SECRET                    HS256 密钥，设置后为每个 ClientId 签发 JWT
+//	MQTT_JWT_EXPIRED                   已过期的 JWT，未设置时用 MQTT_JWT_SECRET 签发
+//	MQTT_JWT_CLIENT_CLAIM              签发时存放 ClientId 的声明名，默认 sub
+//	MQTT_JWT_CLAIMS                    签发时附加的声明 (JSON 对象，如 {"aud":"axmq"})
+//	MQTT_JWT_FIELD                     JWT 放在 password (默认) 还是 username 字段
+//	MQTT_WHITELIST_CLIENT_ID           后台白名单中的 ClientId
+//
+// Broker 文档没有说明 Policies 页面的 JWT 引擎期望的令牌布局 (签名算法、ClientId 所在的声明、令牌所在的
+// 字段)。默认值采用常见约定：HS256、sub=ClientId、令牌放在 password 字段且未设置用户名时以 ClientId
+// 作为用户名；与被测 Broker 的 JWT 配置不一致时用上面的变量调整，签名算法固定为 HS256。
+//
+// newClientOptions 和 rawConnect 会自动带上这些凭据；TestMQTT_Auth 覆盖各策略的接受/拒绝行为，
+// 未配置对应策略时跳过。
+
+type credentials struct {
+	Username          string         `json:"username"`
+	Password          string         `json:"password"`
+	JWT               string         `json:"jwt"`
+	JWTSecret         string         `json:"jwt_secret"`
+	ExpiredJWT        string         `json:"expired_jwt"`
+	JWTClientClaim    string         `json:"jwt_client_claim"`
+	JWTClaims         map[string]any `json:"jwt_claims"`
+	JWTField          string         `json:"jwt_field"`
+	WhitelistClientID string         `json:"whitelist_client_id"`
+}
+
+// testCredentials 读取一次凭据配置，文件不可读时返回错误 (客户端工厂按无凭据处理)。
+var testCredentials = sync.OnceValues(func() (credentials, error) {
+	var c credentials
+	var err error
+	if path := getEnv("MQTT_AUTH_FILE", ""); path != "" {
+		var data []byte
+		if data, err = os.ReadFile(path); err == nil {
+			err = json.Unmarshal(data, &c)
+		}
+		if err != nil {
+			err = fmt.Errorf("MQTT_AUTH_FILE %s: %w", path, err)
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	for _, f := range []struct {
+		key string
+		dst *string
+	}{
+		{"MQTT_USERNAME", &c.Username},
+		{"MQTT_PASSWORD", &c.Password},
+		{"MQTT_JWT", &c.JWT},
+		{"MQTT_JWT_SECRET", &c.JWTSecret},
+		{"MQTT_JWT_EXPIRED", &c.ExpiredJWT},
+		{"MQTT_JWT_CLIENT_CLAIM", &c.JWTClientClaim},
+		{"MQTT_JWT_FIELD", &c.JWTField},
+		{"MQTT_WHITELIST_CLIENT_ID", &c.WhitelistClientID},
+	} {
+		if v := getEnv(f.key, ""); v != "" {
+			*f.dst = v
+		}
+	}
+	if v := getEnv("MQTT_JWT_CLAIMS", ""); v != "" {
+		if jerr := json.Unmarshal([]byte(v), &c.JWTClaims); jerr != nil {
+			err = fmt.Errorf("MQTT_JWT_CLAIMS: %w", jerr)
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	if c.JWTClientClaim == "" {
+		c.JWTClientClaim = "sub"
+	}
+	if c.JWTField != "username" {
+		c.JWTField = "password"
+	}
+	return c, err
+})
+
+func (c credentials) usesJWT() bool { return c.JWT != "" || c.JWTSecret != "" }
+
+// forClient 返回 clientID 连接时使用的用户名和密码，未配置凭据时均为空。
+func (c credentials) forClient(clientID string) (username, password string) {
+	switch {
+	case c.JWTSecret != "":
+		return c.withToken(clientID, c.mintJWT(c.JWTSecret, clientID, time.Now().Add(time.Hour)))
+	case c.JWT != "":
+		return c.withToken(clientID, c.JWT)
+	default:
+		return c.Username, c.Password
+	}
+}
+
+// withToken 按 JWTField 把令牌放进用户名或密码字段。令牌放在 password 时，MQTT 3.1.1 不允许只有密码，
+// 因此未设置用户名时用 ClientId 代替。
+func (c credentials) withToken(clientID, token string) (username, password string) {
+	if c.JWTField == "username" {
+		return token, c.Password
+	}
+	username = c.Username
+	if username == "" {
+		username = clientID
+	}
+	return username, token
+}
+
+// mintJWT 签发 HS256 JWT，ClientId 放在 JWTClientClaim 声明中，并附加 JWTClaims。
+func (c credentials) mintJWT(secret, clientID string, exp time.Time) string {
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	fields := map[string]any{
+		"iat": time.Now().Add(-2 * time.Hour).Unix(),
+		"exp": exp.Unix(),
+	}
+	for k, v := range c.JWTClaims {
+		fields[k] = v
+	}
+	fields[c.JWTClientClaim] = clientID
+	claims, _ := json.Marshal(fields)
+	signing := header + "." + enc.EncodeToString(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signing))
+	return signing + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+// applyCredentials 为没有显式设置用户名/密码的原生 CONNECT 填入测试凭据。
+func applyCredentials(p *connectPacket) {
+	if p.UsernameFlag || p.PasswordFlag {
+		return
+	}
+	creds, _ := testCredentials()
+	user, pass := creds.forClient(p.ClientID)
+	if user != "" {
+		p.UsernameFlag, p.Username = true, user
+	}
+	if pass != "" {
+		p.PasswordFlag, p.Password = true, []byte(pass)
+	}
+}
+
+// connectReturnCode 发送 p (不自动附加凭据) 并返回 CONNACK 返回码。
+func connectReturnCode(t *testing.T, addr string, p *connectPacket) byte {
+	t.Helper()
+	conn, ack, err := rawHandshake(addr, p, 5*time.Second)
+	if err != nil {
+		t.Fatalf("CONNECT %s: %v", p.ClientID, err)
+	}
+	defer conn.Close()
+	if ack.ReturnCode == 0 {
+		_ = conn.writePacket(&emptyPacket{Type: pktDISCONNECT})
+	}
+	return ack.ReturnCode
+}
+
+// expectNotAuthorized 断言连接被拒绝，返回码为 0x04 (用户名或密码错误) 或 0x05 (未授权)。
+func expectNotAuthorized(t *testing.T, addr string, p *connectPacket) {
+	t.Helper()
+	if rc := connectReturnCode(t, addr, p); rc != 0x04 && rc != 0x05 {
+		t.Fatalf("CONNACK return code %#x, want 0x04 or 0x05", rc)
+	}
+}
+
+func expectAccepted(t *testing.T, addr string, p *connectPacket) {
+	t.Helper()
+	if rc := connectReturnCode(t, addr, p); rc != 0 {
+		t.Fatalf("CONNACK return code %#x, want 0x00", rc)
+	}
+}
+
+func authConnect(id, user, pass string) *connectPacket {
+	return &connectPacket{
+		CleanSession: true, KeepAlive: 60, ClientID: id,
+		UsernameFlag: user != "", Username: user,
+		PasswordFlag: pass != "", Password: []byte(pass),
+	}
+}
+
+func TestMQTT_Auth(t *testing.T) {
+	addr := tcpAddrFromMQTTURL(tcpEndpoint(t, mustEndpoints(t)))
+	creds, err := testCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Basic_Auth", func(t *testing.T) {
+		if creds.Username == "" || creds.Password == "" || creds.usesJWT() {
+			t.Skip("set MQTT_USERNAME and MQTT_PASSWORD (Basic Auth policy) to run")
+		}
+		t.Run("Valid_Credentials", func(t *testing.T) {
+			expectAccepted(t, addr, authConnect("auth_basic_"+randSuffix(), creds.Username, creds.Password))
+		})
+		t.Run("Bad_Password", func(t *testing.T) {
+			expectNotAuthorized(t, addr, authConnect("auth_badpw_"+randSuffix(), creds.Username, creds.Password+"_wrong"))
+		})
+		t.Run("Unknown_User", func(t *testing.T) {
+			expectNotAuthorized(t, addr, authConnect("auth_nouser_"+randSuffix(), "nouser_"+randSuffix(), creds.Password))
+		})
+		t.Run("No_Credentials", func(t *testing.T) {
+			expectNotAuthorized(t, addr, authConnect("auth_anon_"+randSuffix(), "", ""))
+		})
+	})
+
+	t.Run("JWT", func(t *testing.T) {
+		if !creds.usesJWT() {
+			t.Skip("set MQTT_JWT_SECRET or MQTT_JWT (JWT policy) to run")
+		}
+		t.Run("Valid_Token", func(t *testing.T) {
+			id := "auth_jwt_" + randSuffix()
+			user, pass := creds.forClient(id)
+			expectAccepted(t, addr, authConnect(id, user, pass))
+		})
+		t.Run("Expired_Token", func(t *testing.T) {
+			id := "auth_jwtexp_" + randSuffix()
+			token := creds.ExpiredJWT
+			if token == "" {
+				if creds.JWTSecret == "" {
+					t.Skip("set MQTT_JWT_SECRET or MQTT_JWT_EXPIRED to test expired tokens")
+				}
+				token = creds.mintJWT(creds.JWTSecret, id, time.Now().Add(-time.Hour))
+			}
+			user, pass := creds.withToken(id, token)
+			expectNotAuthorized(t, addr, authConnect(id, user, pass))
+		})
+		t.Run("Bad_Signature", func(t *testing.T) {
+			if creds.JWTSecret == "" {
+				t.Skip("set MQTT_JWT_SECRET to test forged tokens")
+			}
+			id := "auth_jwtsig_" + randSuffix()
+			user, pass := creds.withToken(id, creds.mintJWT(creds.JWTSecret+"_forged", id, time.Now().Add(time.Hour)))
+			expectNotAuthorized(t, addr, authConnect(id, user, pass))
+		})
+		t.Run("No_Token", func(t *testing.T) {
+			expectNotAuthorized(t, addr, authConnect("auth_jwtnone_"+randSuffix(), "", ""))
+		})
+	})
+
+	t.Run("Whitelist", func(t *testing.T) {
+		if creds.WhitelistClientID == "" {
+			t.Skip("set MQTT_WHITELIST_CLIENT_ID (ClientId whitelist policy) to run")
+		}
+		t.Run("Whitelisted_ClientID_Accepted", func(t *testing.T) {
+			expectAccepted(t, addr, authConnect(creds.WhitelistClientID, "", ""))
+		})
+		t.Run("Other_ClientID_Rejected", func(t *testing.T) {
+			expectNotAuthorized(t, addr, authConnect("auth_notlisted_"+randSuffix(), "", ""))
+		})
+	})
+}
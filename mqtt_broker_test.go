@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 自托管 Broker：未设置 MQTT_TCP_URL/MQTT_WS_URL 时，TestMain 在临时目录生成 conf.yml
+// 并拉起本地 ApexMQTT 进程，所有端口均为随机空闲端口，测试结束后关闭进程并删除数据目录。
+//
+// 二进制查找顺序：MQTT_BROKER_BIN，然后是 PATH 中的 ApexMQTT / ApexMQTT_amd64_linux。
+// 两者都找不到时功能测试被跳过，而不是连接公网 Broker。
+
+var brokerBinaryNames = []string{"ApexMQTT", "ApexMQTT_amd64_linux"}
+
+// localBroker 是 TestMain 拉起的 Broker，使用外部 Broker 时为 nil。
+var localBroker *brokerProcess
+
+// localTLSBroker 是 TestMain 拉起的 TLS Broker (ssl:// 与 wss://)，MQTT_TLS=0 或启动失败时为 nil。
+var localTLSBroker *brokerProcess
+
+// brokerConfig 对应 conf.yml 中的配置项。
+type brokerConfig struct {
+	DashboardPass   string
+	StoragePath     string
+	Host            string
+	TCPTLSPem       string
+	TCPTLSKey       string
+	WebsocketHost   string
+	WebsocketTLSPem string
+	WebsocketTLSKey string
+}
+
+func (c brokerConfig) yaml() string {
+	var b strings.Builder
+	kv := func(k, v string) { fmt.Fprintf(&b, "%s: %q\n", k, v) }
+	b.WriteString("# generated by mqtt_broker_test.go\n")
+	kv("dashboard_pass", c.DashboardPass)
+	kv("storage_path", c.StoragePath)
+	kv("host", c.Host)
+	kv("tcp_tls_pem", c.TCPTLSPem)
+	kv("tcp_tls_key", c.TCPTLSKey)
+	kv("websocket_host", c.WebsocketHost)
+	kv("websocket_tls_pem", c.WebsocketTLSPem)
+	kv("websocket_tls_key", c.WebsocketTLSKey)
+	return b.String()
+}
+
+type brokerProcess struct {
+	dir  string
+	cfg  brokerConfig
+	cmd  *exec.Cmd
+	log  *os.File
+	done chan error
+}
+
+func findBrokerBinary() string {
+	if p := getEnv("MQTT_BROKER_BIN", ""); p != "" {
+		return p
+	}
+	for _, name := range brokerBinaryNames {
+		if p, err := exec.LookPath(name); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// freeAddr 返回 127.0.0.1 上一个当前空闲的 TCP 地址。
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+// newLocalBrokerConfig 为 dir 生成一份使用随机端口的配置。
+// conf.yml 没有后台监听地址配置项 (后台固定监听 :80)，因此启动时不等待后台端口。
+func newLocalBrokerConfig(dir string) (brokerConfig, error) {
+	var addrs [2]string
+	for i := range addrs {
+		a, err := freeAddr()
+		if err != nil {
+			return brokerConfig{}, err
+		}
+		addrs[i] = a
+	}
+	return brokerConfig{
+		DashboardPass: "test_" + randSuffix(),
+		StoragePath:   filepath.Join(dir, "data"),
+		Host:          addrs[0],
+		WebsocketHost: addrs[1],
+	}, nil
+}
+
+// startBroker 在 dir 中写入 conf.yml 并启动 Broker，直到 MQTT 监听端口可连接才返回。
+func startBroker(bin, dir string, cfg brokerConfig) (*brokerProcess, error) {
+	if err := os.WriteFile(filepath.Join(dir, "conf.yml"), []byte(cfg.yaml()), 0o644); err != nil {
+		return nil, err
+	}
+	logf, err := os.Create(filepath.Join(dir, "broker.log"))
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(bin)
+	cmd.Dir = dir // Broker 从工作目录读取 conf.yml
+	cmd.Stdout = logf
+	cmd.Stderr = logf
+	if err := cmd.Start(); err != nil {
+		logf.Close()
+		return nil, err
+	}
+	b := &brokerProcess{dir: dir, cfg: cfg, cmd: cmd, log: logf, done: make(chan error, 1)}
+	go func() { b.done <- cmd.Wait() }()
+
+	timeout := time.Duration(getEnvIntOr("MQTT_BROKER_START_TIMEOUT", 15)) * time.Second
+	for _, addr := range []string{cfg.Host, cfg.WebsocketHost} {
+		if err := b.waitListening(addr, timeout); err != nil {
+			b.stop()
+			return nil, fmt.Errorf("%w\n%s", err, b.logTail(2048))
+		}
+	}
+	return b, nil
+}
+
+func (b *brokerProcess) waitListening(addr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-b.done:
+			b.done <- err
+			return fmt.Errorf("broker exited before listening on %s: %v", addr, err)
+		default:
+		}
+		if c, err := net.DialTimeout("tcp", addr, 500*time.Millisecond); err == nil {
+			c.Close()
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("broker not listening on %s after %v", addr, timeout)
+}
+
+// alive 报告 Broker 进程是否仍在运行。
+func (b *brokerProcess) alive() bool {
+	select {
+	case err := <-b.done:
+		b.done <- err
+		return false
+	default:
+		return true
+	}
+}
+
+// stop 发送 SIGTERM 触发优雅退出，超时后强制结束进程。
+func (b *brokerProcess) stop() {
+	defer b.log.Close()
+	_ = b.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-b.done:
+	case <-time.After(10 * time.Second):
+		_ = b.cmd.Process.Kill()
+		<-b.done
+	}
+}
+
+func (b *brokerProcess) logTail(n int) string {
+	data, _ := os.ReadFile(b.log.Name())
+	if len(data) > n {
+		data = data[len(data)-n:]
+	}
+	return string(data)
+}
+
+// endpoints 返回 Broker 的监听地址，配置了证书的监听器使用 ssl:// / wss://。
+func (b *brokerProcess) endpoints() []brokerEndpoint {
+	tcp := brokerEndpoint{name: "tcp", url: "tcp://" + b.cfg.Host, broker: b.dir}
+	if b.cfg.TCPTLSPem != "" {
+		tcp.name, tcp.url = "ssl", "ssl://"+b.cfg.Host
+	}
+	ws := brokerEndpoint{name: "ws", url: "ws://" + b.cfg.WebsocketHost, broker: b.dir}
+	if b.cfg.WebsocketTLSPem != "" {
+		ws.name, ws.url = "wss", "wss://"+b.cfg.WebsocketHost
+	}
+	return []brokerEndpoint{tcp, ws}
+}
+
+// getEnvIntOr 与 getEnvInt 相同，但用于没有 *testing.T 的场景 (如 TestMain)。
+func getEnvIntOr(key string, def int) int {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+		fmt.Fprintf(os.Stderr, "invalid %s=%q, using %d\n", key, v, def)
+	}
+	return def
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(runWithLocalBroker(m))
+}
+
+func runWithLocalBroker(m *testing.M) int {
+	if externalBroker() {
+		return runLeakChecked(m)
+	}
+	bin := findBrokerBinary()
+	if bin == "" {
+		fmt.Fprintln(os.Stderr, "ApexMQTT binary not found (set MQTT_BROKER_BIN or add it to PATH); broker tests will be skipped")
+		return runLeakChecked(m)
+	}
+
+	dir, err := os.MkdirTemp("", "axmq-test-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "create broker dir:", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, err := newLocalBrokerConfig(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "allocate broker ports:", err)
+		return 1
+	}
+	b, err := startBroker(bin, dir, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "start broker:", err)
+		return 1
+	}
+	defer b.stop()
+	localBroker = b
+
+	if getEnv("MQTT_TLS", "1") != "0" {
+		tlsDir := filepath.Join(dir, "tls")
+		if err := os.Mkdir(tlsDir, 0o755); err != nil {
+			fmt.Fprintln(os.Stderr, "create tls broker dir:", err)
+			return 1
+		}
+		tb, f, err := startTLSBroker(bin, tlsDir)
+		if err != nil {
+			// TLS 覆盖是附加的，启动失败时只跳过 ssl:// / wss:// 用例
+			fmt.Fprintln(os.Stderr, "start tls broker (ssl/wss tests will be skipped):", err)
+		} else {
+			defer tb.stop()
+			localTLSBroker, testTLSFixture = tb, f
+		}
+	}
+	return runLeakChecked(m)
+}
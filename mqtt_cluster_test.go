@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// 本地回环集群：以 node1/cluster.yml 为模板，为每个节点渲染独立的 cluster.yml 和 conf.yml
+// (gossip_port / forward_port / host / storage_path 各不相同，secret_key 与 seeds 共享)，
+// 全部运行在 127.0.0.1 上，便于在单机上用 go test 验证跨节点行为。
+//
+// 设置 MQTT_CLUSTER_URLS=tcp://a:1883,tcp://b:1883 时改为使用已部署的集群，此时无法停止节点。
+
+const defaultClusterTemplate = "node1/cluster.yml"
+
+type clusterNode struct {
+	name       string
+	url        string
+	dir        string
+	gossipAddr string
+	cfg        brokerConfig
+	broker     *brokerProcess // 外部集群或节点已停止时为 nil
+}
+
+type testCluster struct {
+	bin    string
+	secret string
+	nodes  []*clusterNode
+}
+
+var (
+	reClusterName    = regexp.MustCompile(`(?m)^(\s*)name:.*$`)
+	reClusterAddr    = regexp.MustCompile(`(?m)^(\s*)addr:.*$`)
+	reClusterGossip  = regexp.MustCompile(`(?m)^(\s*)gossip_port:.*$`)
+	reClusterForward = regexp.MustCompile(`(?m)^(\s*)forward_port:.*$`)
+	reClusterSecret  = regexp.MustCompile(`(?m)^(\s*)secret_key:.*$`)
+	reClusterSeeds   = regexp.MustCompile(`(?m)^seeds:\n(?:[ \t]+-.*\n)*`)
+)
+
+// renderClusterConfig 用节点参数替换模板中的对应字段，其余配置 (如 routing) 原样保留。
+func renderClusterConfig(tmpl, name, addr string, gossipPort, forwardPort int, secret string, seeds []string) string {
+	out := reClusterName.ReplaceAllString(tmpl, "${1}name: "+name)
+	out = reClusterAddr.ReplaceAllString(out, "${1}addr: "+addr)
+	out = reClusterGossip.ReplaceAllString(out, "${1}gossip_port: "+strconv.Itoa(gossipPort))
+	out = reClusterForward.ReplaceAllString(out, "${1}forward_port: "+strconv.Itoa(forwardPort))
+	out = reClusterSecret.ReplaceAllString(out, "${1}secret_key: "+strconv.Quote(secret))
+	var b strings.Builder
+	b.WriteString("seeds:\n")
+	for _, s := range seeds {
+		b.WriteString("  - " + s + "\n")
+	}
+	return reClusterSeeds.ReplaceAllString(out, b.String())
+}
+
+// freeGossipAddr 返回 TCP 与 UDP 同时空闲的地址 (memberlist 两者都要监听)。
+func freeGossipAddr() (string, error) {
+	for i := 0; i < 20; i++ {
+		addr, err := freeAddr()
+		if err != nil {
+			return "", err
+		}
+		if u, err := net.ListenPacket("udp", addr); err == nil {
+			u.Close()
+			return addr, nil
+		}
+	}
+	return "", fmt.Errorf("no free tcp+udp port for gossip")
+}
+
+func addrPort(addr string) int {
+	_, p, _ := net.SplitHostPort(addr)
+	n, _ := strconv.Atoi(p)
+	return n
+}
+
+// newTestCluster 返回一个可用的集群：优先使用 MQTT_CLUSTER_URLS，否则在本地启动 n 个节点。
+func newTestCluster(t *testing.T, n int) *testCluster {
+	t.Helper()
+	if urls := getEnv("MQTT_CLUSTER_URLS", ""); urls != "" {
+		c := &testCluster{}
+		for i, u := range strings.Split(urls, ",") {
+			c.nodes = append(c.nodes, &clusterNode{name: "node-" + strconv.Itoa(i+1), url: strings.TrimSpace(u)})
+		}
+		if len(c.nodes) < 2 {
+			t.Skip("MQTT_CLUSTER_URLS needs at least 2 nodes")
+		}
+		return c
+	}
+	if testing.Short() {
+		t.Skip("skipping local cluster in -short mode")
+	}
+	bin := findBrokerBinary()
+	if bin == "" {
+		t.Skip("no broker: set MQTT_BROKER_BIN (or put ApexMQTT in PATH), or MQTT_CLUSTER_URLS")
+	}
+	c := startLocalCluster(t, bin, n)
+	c.waitMembership(t, time.Duration(getEnvInt(t, "MQTT_CLUSTER_JOIN_TIMEOUT", 30))*time.Second)
+	return c
+}
+
+func startLocalCluster(t *testing.T, bin string, n int) *testCluster {
+	t.Helper()
+	tmpl, err := os.ReadFile(getEnv("MQTT_CLUSTER_TEMPLATE", defaultClusterTemplate))
+	if err != nil {
+		t.Fatalf("read cluster template: %v", err)
+	}
+
+	c := &testCluster{bin: bin, secret: "axmq-test-" + randSuffix()}
+	var seeds []string
+	for i := 0; i < n; i++ {
+		dir := t.TempDir()
+		cfg, err := newLocalBrokerConfig(dir)
+		if err != nil {
+			t.Fatalf("allocate broker ports: %v", err)
+		}
+		gossip, err := freeGossipAddr()
+		if err != nil {
+			t.Fatalf("allocate gossip port: %v", err)
+		}
+		c.nodes = append(c.nodes, &clusterNode{
+			name:       "node-" + strconv.Itoa(i+1),
+			url:        "tcp://" + cfg.Host,
+			dir:        dir,
+			gossipAddr: gossip,
+			cfg:        cfg,
+		})
+		seeds = append(seeds, gossip)
+	}
+
+	for _, node := range c.nodes {
+		forward, err := freeAddr()
+		if err != nil {
+			t.Fatalf("allocate forward port: %v", err)
+		}
+		yml := renderClusterConfig(string(tmpl), node.name, "127.0.0.1", addrPort(node.gossipAddr), addrPort(forward), c.secret, seeds)
+		if err := os.WriteFile(filepath.Join(node.dir, "cluster.yml"), []byte(yml), 0o644); err != nil {
+			t.Fatalf("write cluster.yml: %v", err)
+		}
+	}
+
+	for _, node := range c.nodes {
+		b, err := startBroker(bin, node.dir, node.cfg)
+		if err != nil {
+			t.Fatalf("start %s: %v", node.name, err)
+		}
+		node.broker = b
+	}
+	t.Cleanup(func() {
+		for _, node := range c.nodes {
+			if node.broker != nil {
+				node.broker.stop()
+				node.broker = nil
+			}
+		}
+	})
+	return c
+}
+
+// stopNode 向节点发送 SIGTERM 并等待其退出 (触发集群优雅下线)。
+func (c *testCluster) stopNode(t *testing.T, i int) {
+	t.Helper()
+	node := c.nodes[i]
+	if node.broker == nil {
+		t.Skipf("cannot stop %s: not a locally launched node", node.name)
+	}
+	node.broker.stop()
+	node.broker = nil
+}
+
+// liveNodes 返回仍在运行的节点下标。
+func (c *testCluster) liveNodes() []int {
+	var out []int
+	for i, node := range c.nodes {
+		if node.broker != nil || c.bin == "" {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// waitMembership 在每个节点上订阅探测主题，并从其他每个节点发布，
+// 直到所有节点两两之间都能转发消息，即每个节点都已看到完整的集群成员。
+func (c *testCluster) waitMembership(t *testing.T, timeout time.Duration) {
+	t.Helper()
+	base := "cluster/probe/" + randSuffix()
+	live := c.liveNodes()
+
+	var mu sync.Mutex
+	seen := make(map[[2]int]bool) // {from, to}
+	pubs := make(map[int]mqtt.Client)
+	for _, i := range live {
+		i := i
+		sub := createClient(c.nodes[i].url, "probe_sub_"+strconv.Itoa(i)+"_"+randSuffix(), true)
+		mustConnect(t, sub, 5*time.Second)
+		defer sub.Disconnect(250)
+		mustWaitToken(t, sub.Subscribe(base+"/"+strconv.Itoa(i), 0, func(client mqtt.Client, msg mqtt.Message) {
+			from, err := strconv.Atoi(string(msg.Payload()))
+			if err == nil {
+				mu.Lock()
+				seen[[2]int{from, i}] = true
+				mu.Unlock()
+			}
+		}), 5*time.Second, "probe subscribe")
+
+		pub := createClient(c.nodes[i].url, "probe_pub_"+strconv.Itoa(i)+"_"+randSuffix(), true)
+		mustConnect(t, pub, 5*time.Second)
+		defer pub.Disconnect(250)
+		pubs[i] = pub
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var missing []string
+		mu.Lock()
+		for _, from := range live {
+			for _, to := range live {
+				if from != to && !seen[[2]int{from, to}] {
+					missing = append(missing, c.nodes[from].name+"->"+c.nodes[to].name)
+					pubs[from].Publish(base+"/"+strconv.Itoa(to), 0, false, strconv.Itoa(from))
+				}
+			}
+		}
+		mu.Unlock()
+		if len(missing) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			sort.Strings(missing)
+			t.Fatalf("cluster did not converge within %v, missing routes: %s", timeout, strings.Join(missing, ", "))
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func TestMQTT_Cluster(t *testing.T) {
+	c := newTestCluster(t, getEnvInt(t, "MQTT_CLUSTER_NODES", 3))
+
+	t.Run("CrossNode_PubSub", func(t *testing.T) {
+		for _, from := range c.liveNodes() {
+			for _, to := range c.liveNodes() {
+				if from == to {
+					continue
+				}
+				pubNode, subNode := c.nodes[from], c.nodes[to]
+				t.Run(pubNode.name+"_to_"+subNode.name, func(t *testing.T) {
+					topic := topicWithSuffix("cluster/test/cross")
+					payload := "cross_" + randSuffix()
+
+					sub := createClient(subNode.url, "cross_sub_"+randSuffix(), true)
+					mustConnect(t, sub, 5*time.Second)
+					defer sub.Disconnect(250)
+
+					wg := sync.WaitGroup{}
+					wg.Add(1)
+					var once sync.Once
+					mustWaitToken(t, sub.Subscribe(topic, 1, func(client mqtt.Client, msg mqtt.Message) {
+						if string(msg.Payload()) == payload {
+							once.Do(func() { wg.Done() })
+						}
+					}), 5*time.Second, "subscribe")
+
+					pub := createClient(pubNode.url, "cross_pub_"+randSuffix(), true)
+					mustConnect(t, pub, 5*time.Second)
+					defer pub.Disconnect(250)
+
+					// 订阅变更会立即触发能力同步，但仍需给路由一点时间
+					time.Sleep(500 * time.Millisecond)
+					mustWaitToken(t, pub.Publish(topic, 1, false, payload), 10*time.Second, "publish")
+					if waitTimeout(&wg, 10*time.Second) {
+						t.Fatal("message not forwarded across nodes")
+					}
+				})
+			}
+		}
+	})
+
+	t.Run("Persistent_Session_CrossNode", func(t *testing.T) {
+		// 在第一个节点建立会话，重连到另一个节点，发布者在第一个节点
+		live := c.liveNodes()
+		if len(live) < 2 {
+			t.Skipf("cross-node session needs at least 2 live nodes, have %d", len(live))
+		}
+		first, second := c.nodes[live[0]], c.nodes[live[1]]
+		testPersistentSession(t, first.url, second.url, first.url)
+	})
+
+	t.Run("CrossNode_Retain", func(t *testing.T) {
+		live := c.liveNodes()
+		pubNode, subNode := c.nodes[live[0]], c.nodes[live[len(live)-1]]
+		topic := topicWithSuffix("cluster/test/retain")
+		payload := "retained_" + randSuffix()
+
+		pub := createClient(pubNode.url, "cretain_pub_"+randSuffix(), true)
+		mustConnect(t, pub, 5*time.Second)
+		defer pub.Disconnect(250)
+		defer func() {
+			pub.Publish(topic, 1, true, []byte{}).WaitTimeout(5 * time.Second)
+		}()
+		mustWaitToken(t, pub.Publish(topic, 1, true, payload), 10*time.Second, "publish retain")
+
+		// Retained 消息跨节点同步是异步的，反复订阅直到拿到
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			got := make(chan struct{}, 1)
+			sub := createClient(subNode.url, "cretain_sub_"+randSuffix(), true)
+			mustConnect(t, sub, 5*time.Second)
+			mustWaitToken(t, sub.Subscribe(topic, 1, func(client mqtt.Client, msg mqtt.Message) {
+				if string(msg.Payload()) == payload {
+					select {
+					case got <- struct{}{}:
+					default:
+					}
+				}
+			}), 5*time.Second, "subscribe")
+			select {
+			case <-got:
+				sub.Disconnect(250)
+				return
+			case <-time.After(time.Second):
+			}
+			sub.Disconnect(250)
+			if time.Now().After(deadline) {
+				t.Fatalf("retained message from %s not visible on %s", pubNode.name, subNode.name)
+			}
+		}
+	})
+}
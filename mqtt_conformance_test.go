@@ -0,0 +1,740 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// MQTT 3.1.1 规范性语句一致性矩阵：每条用例以规范中的编号 (如 MQTT-3.1.0-1) 命名，使用原生 socket 验证
+// Broker 侧的 MUST 要求。运行结束后输出每条语句的 pass/fail/skip；设置 MQTT_CONFORMANCE_REPORT=path
+// 时写入 path.md (Markdown 表格) 和 path.json。
+//
+// 标记为 malformed 的用例会发送非法报文，可能触发 IpBlocker，需要设置
+// MQTT_CONFORMANCE_NO_BLOCKER=1 (确认 Broker 不会封禁测试 IP) 才执行，否则记为 skip。
+
+type conformanceCase struct {
+	id        string
+	desc      string
+	malformed bool
+	run       func(t *testing.T, addr string)
+}
+
+type conformanceResult struct {
+	ID     string `json:"id"`
+	Desc   string `json:"description"`
+	Result string `json:"result"` // pass / fail / skip
+}
+
+// confConnect 建立原生连接并要求 CONNACK 返回码为 0，连接在测试结束时关闭。
+func confConnect(t *testing.T, addr, id string, clean bool) (*packetConn, *connackPacket) {
+	t.Helper()
+	conn, ack, err := rawConnect(addr, &connectPacket{CleanSession: clean, KeepAlive: 60, ClientID: id}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("CONNECT %q: %v", id, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if ack.ReturnCode != 0 {
+		t.Fatalf("CONNACK refused: return code %#x", ack.ReturnCode)
+	}
+	return conn, ack
+}
+
+func confClient(t *testing.T, addr string) *packetConn {
+	t.Helper()
+	conn, _ := confConnect(t, addr, "conf_"+randSuffix(), true)
+	return conn
+}
+
+// confSubscribe 订阅单个过滤器并返回授予的 QoS。
+func confSubscribe(t *testing.T, conn *packetConn, id uint16, filter string, qos byte) byte {
+	t.Helper()
+	if err := conn.writePacket(&subscribePacket{PacketID: id, Topics: []subscription{{Filter: filter, QoS: qos}}}); err != nil {
+		t.Fatalf("write SUBSCRIBE: %v", err)
+	}
+	ack, err := expectPacket[*subackPacket](conn, 5*time.Second)
+	if err != nil {
+		t.Fatalf("waiting for SUBACK: %v", err)
+	}
+	if len(ack.ReturnCodes) != 1 {
+		t.Fatalf("SUBACK has %d return codes, want 1", len(ack.ReturnCodes))
+	}
+	return ack.ReturnCodes[0]
+}
+
+// confPublish 发布一条消息，QoS 1 时等待 PUBACK。
+func confPublish(t *testing.T, conn *packetConn, p *publishPacket) {
+	t.Helper()
+	if err := conn.writePacket(p); err != nil {
+		t.Fatalf("write PUBLISH: %v", err)
+	}
+	if p.QoS == 1 {
+		mustExpectAck(t, conn, pktPUBACK, p.PacketID)
+	}
+}
+
+func confExpectPublish(t *testing.T, conn *packetConn) *publishPacket {
+	t.Helper()
+	p, err := expectPacket[*publishPacket](conn, 5*time.Second)
+	if err != nil {
+		t.Fatalf("waiting for PUBLISH: %v", err)
+	}
+	if p.QoS == 1 {
+		_ = conn.writePacket(&ackPacket{Type: pktPUBACK, PacketID: p.PacketID})
+	}
+	return p
+}
+
+// expectClosed 断言 Broker 在 timeout 内关闭连接，期间收到的报文被忽略。
+func expectClosed(t *testing.T, conn *packetConn, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		_, _, err := conn.readFrame(time.Until(deadline))
+		if err == nil {
+			continue
+		}
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			t.Fatalf("connection still open after %v", timeout)
+		}
+		return
+	}
+}
+
+// expectRefused 断言非法 CONNECT 被拒绝：连接被关闭，或返回非 0 的 CONNACK 后关闭。
+func expectRefused(t *testing.T, addr string, frame []byte) {
+	t.Helper()
+	conn, err := tcpDial(addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write(frame)
+	p, err := conn.readPacket(5 * time.Second)
+	if err != nil {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			t.Fatal("no CONNACK and connection still open")
+		}
+		return
+	}
+	if ack, ok := p.(*connackPacket); ok && ack.ReturnCode == 0 {
+		t.Fatal("invalid CONNECT accepted with return code 0")
+	}
+	expectClosed(t, conn, 5*time.Second)
+}
+
+// connectFrame 编码 CONNECT 并把连接标志字节替换为 flags，用于构造编码器不会生成的组合。
+func connectFrame(id string, flags byte) []byte {
+	body := (&connectPacket{KeepAlive: 60, ClientID: id}).body()
+	body[7] = flags // 协议名 (6 字节) + 协议级别 (1 字节) 之后
+	return encodeFrame(pktCONNECT<<4, body)
+}
+
+// sendMalformed 在已连接的会话上发送 frame 并断言连接被关闭。
+func sendMalformed(t *testing.T, addr string, frame []byte) {
+	t.Helper()
+	conn := confClient(t, addr)
+	_, _ = conn.Write(frame)
+	expectClosed(t, conn, 5*time.Second)
+}
+
+// clearRetained 发布零字节保留消息以清理主题。
+func clearRetained(addr, topic string) {
+	if conn, _, err := rawConnect(addr, &connectPacket{CleanSession: true, KeepAlive: 60, ClientID: "conf_clear_" + randSuffix()}, 5*time.Second); err == nil {
+		_ = conn.writePacket(&publishPacket{QoS: 1, PacketID: 1, Retain: true, Topic: topic})
+		_ = expectAck(conn, pktPUBACK, 1, 2*time.Second)
+		conn.Close()
+	}
+}
+
+func conformanceCases() []conformanceCase {
+	willCase := func(t *testing.T, addr string, retain bool, disconnect bool) (*packetConn, string, string) {
+		topic := topicWithSuffix("cp7/test/conf_will")
+		payload := "will_" + randSuffix()
+		sub := confClient(t, addr)
+		confSubscribe(t, sub, 1, topic, 1)
+		conn, ack, err := rawConnect(addr, &connectPacket{
+			CleanSession: true, KeepAlive: 60, ClientID: "conf_will_" + randSuffix(),
+			WillFlag: true, WillQoS: 1, WillRetain: retain, WillTopic: topic, WillMessage: []byte(payload),
+		}, 5*time.Second)
+		if err != nil || ack.ReturnCode != 0 {
+			t.Fatalf("CONNECT with will: %v %+v", err, ack)
+		}
+		if disconnect {
+			_ = conn.writePacket(&emptyPacket{Type: pktDISCONNECT})
+		}
+		conn.Close()
+		return sub, topic, payload
+	}
+
+	return []conformanceCase{
+		// 3.1 CONNECT
+		{id: "MQTT-3.1.0-1", desc: "客户端发送的第一个报文必须是 CONNECT", malformed: true, run: func(t *testing.T, addr string) {
+			conn, err := tcpDial(addr, 5*time.Second)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer conn.Close()
+			_ = conn.writePacket(&publishPacket{Topic: "cp7/test/conf/first", Payload: []byte("x")})
+			expectClosed(t, conn, 5*time.Second)
+		}},
+		{id: "MQTT-3.1.0-2", desc: "第二个 CONNECT 视为协议违规并断开", malformed: true, run: func(t *testing.T, addr string) {
+			sendMalformed(t, addr, encodePacket(&connectPacket{KeepAlive: 60, ClientID: "conf_dup_" + randSuffix()}))
+		}},
+		{id: "MQTT-3.1.2-2", desc: "不支持的协议级别返回 0x01 并断开", run: func(t *testing.T, addr string) {
+			conn, ack, err := rawConnect(addr, &connectPacket{ProtocolLevel: 7, CleanSession: true, KeepAlive: 60, ClientID: "conf_lvl_" + randSuffix()}, 5*time.Second)
+			if err != nil {
+				t.Fatalf("expected CONNACK 0x01 before close, got %v", err)
+			}
+			defer conn.Close()
+			if ack.ReturnCode != 0x01 {
+				t.Fatalf("return code %#x, want 0x01", ack.ReturnCode)
+			}
+		}},
+		{id: "MQTT-3.1.2-3", desc: "连接标志保留位必须为 0，否则断开", malformed: true, run: func(t *testing.T, addr string) {
+			expectRefused(t, addr, encodePacket(&connectPacket{Reserved: true, CleanSession: true, KeepAlive: 60, ClientID: "conf_rsv_" + randSuffix()}))
+		}},
+		{id: "MQTT-3.1.2-8", desc: "网络连接异常关闭时发布遗嘱", run: func(t *testing.T, addr string) {
+			sub, _, payload := willCase(t, addr, false, false)
+			if p := confExpectPublish(t, sub); string(p.Payload) != payload {
+				t.Fatalf("will payload %q, want %q", p.Payload, payload)
+			}
+		}},
+		{id: "MQTT-3.1.2-10", desc: "收到 DISCONNECT 后删除遗嘱，不再发布", run: func(t *testing.T, addr string) {
+			sub, _, _ := willCase(t, addr, false, true)
+			expectNoPublish(t, sub, time.Second)
+		}},
+		{id: "MQTT-3.1.2-13", desc: "Will Flag 为 0 时 Will QoS 必须为 0", malformed: true, run: func(t *testing.T, addr string) {
+			expectRefused(t, addr, connectFrame("conf_wq_"+randSuffix(), 0x02|0x08))
+		}},
+		{id: "MQTT-3.1.2-14", desc: "Will QoS 不能为 3", malformed: true, run: func(t *testing.T, addr string) {
+			expectRefused(t, addr, encodePacket(&connectPacket{CleanSession: true, KeepAlive: 60, ClientID: "conf_wq3_" + randSuffix(),
+				WillFlag: true, WillQoS: 3, WillTopic: "cp7/test/conf/w", WillMessage: []byte("x")}))
+		}},
+		{id: "MQTT-3.1.2-15", desc: "Will Flag 为 0 时 Will Retain 必须为 0", malformed: true, run: func(t *testing.T, addr string) {
+			expectRefused(t, addr, connectFrame("conf_wr_"+randSuffix(), 0x02|0x20))
+		}},
+		{id: "MQTT-3.1.2-17", desc: "Will Retain 为 1 时遗嘱作为保留消息发布", run: func(t *testing.T, addr string) {
+			_, topic, payload := willCase(t, addr, true, false)
+			defer clearRetained(addr, topic)
+			time.Sleep(500 * time.Millisecond)
+			sub := confClient(t, addr)
+			confSubscribe(t, sub, 1, topic, 1)
+			p := confExpectPublish(t, sub)
+			if string(p.Payload) != payload || !p.Retain {
+				t.Fatalf("got %q retain=%v, want retained will %q", p.Payload, p.Retain, payload)
+			}
+		}},
+		{id: "MQTT-3.1.2-22", desc: "User Name Flag 为 0 时 Password Flag 必须为 0", malformed: true, run: func(t *testing.T, addr string) {
+			expectRefused(t, addr, encodePacket(&connectPacket{CleanSession: true, KeepAlive: 60, ClientID: "conf_pw_" + randSuffix(),
+				PasswordFlag: true, Password: []byte("x")}))
+		}},
+		{id: "MQTT-3.1.2-24", desc: "1.5 倍 Keep Alive 内无报文时断开", run: func(t *testing.T, addr string) {
+			conn, ack, err := rawConnect(addr, &connectPacket{CleanSession: true, KeepAlive: 1, ClientID: "conf_ka_" + randSuffix()}, 5*time.Second)
+			if err != nil || ack.ReturnCode != 0 {
+				t.Fatalf("CONNECT: %v %+v", err, ack)
+			}
+			defer conn.Close()
+			tolerance := time.Duration(getEnvInt(t, "MQTT_KEEPALIVE_TOLERANCE_MS", 1000)) * time.Millisecond
+			expectClosed(t, conn, 1500*time.Millisecond+tolerance)
+		}},
+		{id: "MQTT-3.1.3-5", desc: "接受 1-23 字节的字母数字 ClientId", run: func(t *testing.T, addr string) {
+			id := "conf" + randSuffix()
+			if len(id) > 23 {
+				id = id[:23]
+			}
+			confConnect(t, addr, id, true)
+		}},
+		{id: "MQTT-3.1.3-6", desc: "接受零字节 ClientId 时必须分配唯一 ID", run: func(t *testing.T, addr string) {
+			// 规范允许 Broker 拒绝零字节 ClientId，此时记为 skip；两个空 ID 连接必须能同时在线
+			c1, ack, err := rawConnect(addr, &connectPacket{CleanSession: true, KeepAlive: 60}, 5*time.Second)
+			if err != nil {
+				t.Fatalf("CONNECT: %v", err)
+			}
+			defer c1.Close()
+			if ack.ReturnCode == 0x02 {
+				t.Skip("broker rejects zero-byte ClientId (allowed by the spec)")
+			}
+			c2, _, err := rawConnect(addr, &connectPacket{CleanSession: true, KeepAlive: 60}, 5*time.Second)
+			if err != nil {
+				t.Fatalf("second CONNECT: %v", err)
+			}
+			defer c2.Close()
+			_ = c1.writePacket(&emptyPacket{Type: pktPINGREQ})
+			if _, err := expectPacket[*emptyPacket](c1, 5*time.Second); err != nil {
+				t.Fatalf("first zero-byte ClientId client was disconnected: %v", err)
+			}
+		}},
+		{id: "MQTT-3.1.3-8", desc: "零字节 ClientId 且 CleanSession=0 时返回 0x02 并断开", run: func(t *testing.T, addr string) {
+			conn, ack, err := rawConnect(addr, &connectPacket{KeepAlive: 60}, 5*time.Second)
+			if err != nil {
+				t.Fatalf("expected CONNACK 0x02 before close, got %v", err)
+			}
+			defer conn.Close()
+			if ack.ReturnCode != 0x02 {
+				t.Fatalf("return code %#x, want 0x02", ack.ReturnCode)
+			}
+			expectClosed(t, conn, 5*time.Second)
+		}},
+		{id: "MQTT-3.1.4-2", desc: "相同 ClientId 的新连接使已有连接断开", run: func(t *testing.T, addr string) {
+			id := "conf_kick_" + randSuffix()
+			old, _ := confConnect(t, addr, id, true)
+			confConnect(t, addr, id, true)
+			expectClosed(t, old, 5*time.Second)
+		}},
+
+		// 3.2 CONNACK
+		{id: "MQTT-3.2.0-1", desc: "Broker 发送的第一个报文是 CONNACK", run: func(t *testing.T, addr string) {
+			confClient(t, addr) // rawConnect 要求第一个报文为 CONNACK
+		}},
+		{id: "MQTT-3.2.2-1", desc: "CleanSession=1 时 Session Present 为 0", run: func(t *testing.T, addr string) {
+			id := "conf_sp1_" + randSuffix()
+			c, _ := confConnect(t, addr, id, false)
+			_ = c.writePacket(&emptyPacket{Type: pktDISCONNECT})
+			c.Close()
+			if _, ack := confConnect(t, addr, id, true); ack.SessionPresent {
+				t.Fatal("session present = 1 with CleanSession=1")
+			}
+		}},
+		{id: "MQTT-3.2.2-2", desc: "恢复已有会话时 Session Present 为 1", run: func(t *testing.T, addr string) {
+			id := "conf_sp2_" + randSuffix()
+			defer rawSessionCleanup(addr, id)
+			c, _ := confConnect(t, addr, id, false)
+			confSubscribe(t, c, 1, topicWithSuffix("cp7/test/conf_sp"), 1)
+			_ = c.writePacket(&emptyPacket{Type: pktDISCONNECT})
+			c.Close()
+			if _, ack := confConnect(t, addr, id, false); !ack.SessionPresent {
+				t.Fatal("session present = 0 when resuming a stored session")
+			}
+		}},
+		{id: "MQTT-3.2.2-3", desc: "没有已保存会话时 Session Present 为 0", run: func(t *testing.T, addr string) {
+			id := "conf_sp3_" + randSuffix()
+			defer rawSessionCleanup(addr, id)
+			if _, ack := confConnect(t, addr, id, false); ack.SessionPresent {
+				t.Fatal("session present = 1 for a new ClientId")
+			}
+		}},
+		{id: "MQTT-3.2.2-4", desc: "返回码非 0 时 Session Present 为 0", run: func(t *testing.T, addr string) {
+			conn, ack, err := rawConnect(addr, &connectPacket{ProtocolLevel: 7, KeepAlive: 60, ClientID: "conf_sp4_" + randSuffix()}, 5*time.Second)
+			if err != nil {
+				t.Fatalf("expected CONNACK, got %v", err)
+			}
+			defer conn.Close()
+			if ack.ReturnCode == 0 || ack.SessionPresent {
+				t.Fatalf("CONNACK %+v, want non-zero return code with session present 0", ack)
+			}
+		}},
+		{id: "MQTT-3.2.2-5", desc: "返回码非 0 时发送 CONNACK 后关闭连接", run: func(t *testing.T, addr string) {
+			conn, ack, err := rawConnect(addr, &connectPacket{ProtocolLevel: 7, KeepAlive: 60, ClientID: "conf_rc_" + randSuffix()}, 5*time.Second)
+			if err != nil {
+				t.Fatalf("expected CONNACK, got %v", err)
+			}
+			defer conn.Close()
+			if ack.ReturnCode == 0 {
+				t.Fatal("unsupported protocol level accepted")
+			}
+			expectClosed(t, conn, 5*time.Second)
+		}},
+
+		// 3.3 PUBLISH
+		{id: "MQTT-3.3.1-4", desc: "QoS 两位都为 1 的 PUBLISH 视为非法并断开", malformed: true, run: func(t *testing.T, addr string) {
+			sendMalformed(t, addr, encodePacket(&publishPacket{QoS: 3, PacketID: 1, Topic: "cp7/test/conf/qos3", Payload: []byte("x")}))
+		}},
+		{id: "MQTT-3.3.1-6", desc: "新订阅建立时发送匹配主题上的保留消息", run: func(t *testing.T, addr string) {
+			topic := topicWithSuffix("cp7/test/conf_retain")
+			defer clearRetained(addr, topic)
+			confPublish(t, confClient(t, addr), &publishPacket{QoS: 1, PacketID: 1, Retain: true, Topic: topic, Payload: []byte("r")})
+			sub := confClient(t, addr)
+			confSubscribe(t, sub, 1, topic, 1)
+			if p := confExpectPublish(t, sub); string(p.Payload) != "r" {
+				t.Fatalf("payload %q, want retained %q", p.Payload, "r")
+			}
+		}},
+		{id: "MQTT-3.3.1-7", desc: "QoS 0 保留消息使先前的保留消息被丢弃", run: func(t *testing.T, addr string) {
+			topic := topicWithSuffix("cp7/test/conf_retain_q0")
+			defer clearRetained(addr, topic)
+			pub := confClient(t, addr)
+			confPublish(t, pub, &publishPacket{QoS: 1, PacketID: 1, Retain: true, Topic: topic, Payload: []byte("old")})
+			confPublish(t, pub, &publishPacket{Retain: true, Topic: topic, Payload: []byte("new")})
+			time.Sleep(300 * time.Millisecond)
+			sub := confClient(t, addr)
+			confSubscribe(t, sub, 1, topic, 1)
+			if p, err := sub.readPacket(time.Second); err == nil {
+				if pp, ok := p.(*publishPacket); ok && string(pp.Payload) == "old" {
+					t.Fatal("previously retained message was not discarded")
+				}
+			}
+		}},
+		{id: "MQTT-3.3.1-8", desc: "因新订阅发送的保留消息 RETAIN 为 1", run: func(t *testing.T, addr string) {
+			topic := topicWithSuffix("cp7/test/conf_retain_flag")
+			defer clearRetained(addr, topic)
+			confPublish(t, confClient(t, addr), &publishPacket{QoS: 1, PacketID: 1, Retain: true, Topic: topic, Payload: []byte("r")})
+			sub := confClient(t, addr)
+			confSubscribe(t, sub, 1, topic, 1)
+			if p := confExpectPublish(t, sub); !p.Retain {
+				t.Fatal("retained message sent on new subscription without RETAIN flag")
+			}
+		}},
+		{id: "MQTT-3.3.1-9", desc: "发给已有订阅的消息 RETAIN 为 0", run: func(t *testing.T, addr string) {
+			topic := topicWithSuffix("cp7/test/conf_retain_live")
+			defer clearRetained(addr, topic)
+			sub := confClient(t, addr)
+			confSubscribe(t, sub, 1, topic, 1)
+			confPublish(t, confClient(t, addr), &publishPacket{QoS: 1, PacketID: 1, Retain: true, Topic: topic, Payload: []byte("r")})
+			if p := confExpectPublish(t, sub); p.Retain {
+				t.Fatal("message forwarded to an established subscription with RETAIN=1")
+			}
+		}},
+		{id: "MQTT-3.3.1-10", desc: "零字节保留消息照常转发给已有订阅者", run: func(t *testing.T, addr string) {
+			topic := topicWithSuffix("cp7/test/conf_retain_empty")
+			sub := confClient(t, addr)
+			confSubscribe(t, sub, 1, topic, 1)
+			confPublish(t, confClient(t, addr), &publishPacket{QoS: 1, PacketID: 1, Retain: true, Topic: topic})
+			if p := confExpectPublish(t, sub); len(p.Payload) != 0 {
+				t.Fatalf("payload %d bytes, want 0", len(p.Payload))
+			}
+		}},
+		{id: "MQTT-3.3.1-11", desc: "零字节保留消息清除已保留消息且自身不被保存", run: func(t *testing.T, addr string) {
+			topic := topicWithSuffix("cp7/test/conf_retain_clear")
+			pub := confClient(t, addr)
+			confPublish(t, pub, &publishPacket{QoS: 1, PacketID: 1, Retain: true, Topic: topic, Payload: []byte("r")})
+			confPublish(t, pub, &publishPacket{QoS: 1, PacketID: 2, Retain: true, Topic: topic})
+			sub := confClient(t, addr)
+			confSubscribe(t, sub, 1, topic, 1)
+			expectNoPublish(t, sub, time.Second)
+		}},
+		{id: "MQTT-3.3.1-12", desc: "RETAIN 为 0 的消息不保存也不替换已有保留消息", run: func(t *testing.T, addr string) {
+			topic := topicWithSuffix("cp7/test/conf_retain_keep")
+			defer clearRetained(addr, topic)
+			pub := confClient(t, addr)
+			confPublish(t, pub, &publishPacket{QoS: 1, PacketID: 1, Retain: true, Topic: topic, Payload: []byte("keep")})
+			confPublish(t, pub, &publishPacket{QoS: 1, PacketID: 2, Topic: topic, Payload: []byte("other")})
+			sub := confClient(t, addr)
+			confSubscribe(t, sub, 1, topic, 1)
+			if p := confExpectPublish(t, sub); string(p.Payload) != "keep" {
+				t.Fatalf("retained payload %q, want %q", p.Payload, "keep")
+			}
+			expectNoPublish(t, sub, 500*time.Millisecond)
+		}},
+		{id: "MQTT-3.3.2-2", desc: "PUBLISH 主题名不能包含通配符", malformed: true, run: func(t *testing.T, addr string) {
+			sendMalformed(t, addr, encodePacket(&publishPacket{Topic: "cp7/test/conf/+/x", Payload: []byte("x")}))
+		}},
+		{id: "MQTT-3.3.2-3", desc: "发给订阅者的主题名与发布时一致", run: func(t *testing.T, addr string) {
+			base := topicWithSuffix("cp7/test/conf_match")
+			sub := confClient(t, addr)
+			confSubscribe(t, sub, 1, base+"/+/x", 1)
+			confPublish(t, confClient(t, addr), &publishPacket{QoS: 1, PacketID: 1, Topic: base + "/a/x", Payload: []byte("m")})
+			if p := confExpectPublish(t, sub); p.Topic != base+"/a/x" {
+				t.Fatalf("topic %q, want %q", p.Topic, base+"/a/x")
+			}
+		}},
+		{id: "MQTT-3.3.4-1", desc: "QoS 1 回复 PUBACK，QoS 2 回复 PUBREC", run: func(t *testing.T, addr string) {
+			conn := confClient(t, addr)
+			topic := topicWithSuffix("cp7/test/conf_ack")
+			_ = conn.writePacket(&publishPacket{QoS: 1, PacketID: 11, Topic: topic, Payload: []byte("1")})
+			mustExpectAck(t, conn, pktPUBACK, 11)
+			_ = conn.writePacket(&publishPacket{QoS: 2, PacketID: 12, Topic: topic, Payload: []byte("2")})
+			mustExpectAck(t, conn, pktPUBREC, 12)
+			_ = conn.writePacket(&ackPacket{Type: pktPUBREL, PacketID: 12})
+			mustExpectAck(t, conn, pktPUBCOMP, 12)
+		}},
+
+		// 3.6 PUBREL
+		{id: "MQTT-3.6.1-1", desc: "PUBREL 固定头标志必须为 0010", malformed: true, run: func(t *testing.T, addr string) {
+			conn := confClient(t, addr)
+			_ = conn.writePacket(&publishPacket{QoS: 2, PacketID: 1, Topic: topicWithSuffix("cp7/test/conf_pubrel"), Payload: []byte("x")})
+			mustExpectAck(t, conn, pktPUBREC, 1)
+			_, _ = conn.Write(encodeFrame(pktPUBREL<<4, []byte{0x00, 0x01}))
+			expectClosed(t, conn, 5*time.Second)
+		}},
+
+		// 3.8 SUBSCRIBE / SUBACK
+		{id: "MQTT-3.8.1-1", desc: "SUBSCRIBE 固定头标志必须为 0010", malformed: true, run: func(t *testing.T, addr string) {
+			body := (&subscribePacket{PacketID: 1, Topics: []subscription{{Filter: "cp7/test/conf/x", QoS: 1}}}).body()
+			sendMalformed(t, addr, encodeFrame(pktSUBSCRIBE<<4, body))
+		}},
+		{id: "MQTT-3.8.3-3", desc: "SUBSCRIBE 至少包含一个订阅项", malformed: true, run: func(t *testing.T, addr string) {
+			sendMalformed(t, addr, encodeFrame(pktSUBSCRIBE<<4|0x02, []byte{0x00, 0x01}))
+		}},
+		{id: "MQTT-3.8.3-4", desc: "请求 QoS 不是 0/1/2 时视为非法并断开", malformed: true, run: func(t *testing.T, addr string) {
+			sendMalformed(t, addr, encodePacket(&subscribePacket{PacketID: 1, Topics: []subscription{{Filter: "cp7/test/conf/x", QoS: 3}}}))
+		}},
+		{id: "MQTT-3.8.4-2", desc: "SUBACK 携带与 SUBSCRIBE 相同的报文标识符", run: func(t *testing.T, addr string) {
+			conn := confClient(t, addr)
+			_ = conn.writePacket(&subscribePacket{PacketID: 0x4242, Topics: []subscription{{Filter: topicWithSuffix("cp7/test/conf_id"), QoS: 1}}})
+			ack, err := expectPacket[*subackPacket](conn, 5*time.Second)
+			if err != nil {
+				t.Fatalf("waiting for SUBACK: %v", err)
+			}
+			if ack.PacketID != 0x4242 {
+				t.Fatalf("SUBACK id %#x, want 0x4242", ack.PacketID)
+			}
+		}},
+		{id: "MQTT-3.8.4-3", desc: "相同过滤器的订阅替换已有订阅", run: func(t *testing.T, addr string) {
+			topic := topicWithSuffix("cp7/test/conf_replace")
+			sub := confClient(t, addr)
+			confSubscribe(t, sub, 1, topic, 0)
+			confSubscribe(t, sub, 2, topic, 1)
+			confPublish(t, confClient(t, addr), &publishPacket{QoS: 1, PacketID: 1, Topic: topic, Payload: []byte("x")})
+			if p := confExpectPublish(t, sub); p.QoS != 1 {
+				t.Fatalf("delivered at QoS %d, want 1 from the replacing subscription", p.QoS)
+			}
+			expectNoPublish(t, sub, 500*time.Millisecond)
+		}},
+		{id: "MQTT-3.8.4-5", desc: "SUBACK 为每个订阅项返回一个返回码", run: func(t *testing.T, addr string) {
+			conn := confClient(t, addr)
+			base := topicWithSuffix("cp7/test/conf_multi")
+			_ = conn.writePacket(&subscribePacket{PacketID: 1, Topics: []subscription{{base + "/0", 0}, {base + "/1", 1}, {base + "/2", 2}}})
+			ack, err := expectPacket[*subackPacket](conn, 5*time.Second)
+			if err != nil {
+				t.Fatalf("waiting for SUBACK: %v", err)
+			}
+			if len(ack.ReturnCodes) != 3 {
+				t.Fatalf("SUBACK has %d return codes, want 3", len(ack.ReturnCodes))
+			}
+		}},
+		{id: "MQTT-3.8.4-6", desc: "投递 QoS 为发布 QoS 与授予 QoS 中的较小值", run: func(t *testing.T, addr string) {
+			pub := confClient(t, addr)
+			for i, c := range []struct{ sub, pub byte }{{1, 2}, {2, 1}, {0, 2}} {
+				topic := topicWithSuffix("cp7/test/conf_min_qos")
+				conn := confClient(t, addr)
+				granted := confSubscribe(t, conn, 1, topic, c.sub)
+				if granted == 0x80 || granted > c.sub {
+					t.Fatalf("requested QoS %d, granted %#x", c.sub, granted)
+				}
+				id := uint16(i + 1)
+				confPublish(t, pub, &publishPacket{QoS: c.pub, PacketID: id, Topic: topic, Payload: []byte("x")})
+				if c.pub == 2 {
+					mustExpectAck(t, pub, pktPUBREC, id)
+					_ = pub.writePacket(&ackPacket{Type: pktPUBREL, PacketID: id})
+					mustExpectAck(t, pub, pktPUBCOMP, id)
+				}
+				if p, want := confExpectPublish(t, conn), min(c.pub, granted); p.QoS != want {
+					t.Fatalf("published at QoS %d to a QoS %d subscription, delivered at QoS %d, want %d", c.pub, granted, p.QoS, want)
+				}
+			}
+		}},
+
+		// 3.10 UNSUBSCRIBE / UNSUBACK
+		{id: "MQTT-3.10.1-1", desc: "UNSUBSCRIBE 固定头标志必须为 0010", malformed: true, run: func(t *testing.T, addr string) {
+			body := (&unsubscribePacket{PacketID: 1, Filters: []string{"cp7/test/conf/x"}}).body()
+			sendMalformed(t, addr, encodeFrame(pktUNSUBSCRIBE<<4, body))
+		}},
+		{id: "MQTT-3.10.3-2", desc: "UNSUBSCRIBE 至少包含一个过滤器", malformed: true, run: func(t *testing.T, addr string) {
+			sendMalformed(t, addr, encodeFrame(pktUNSUBSCRIBE<<4|0x02, []byte{0x00, 0x01}))
+		}},
+		{id: "MQTT-3.10.4-2", desc: "取消订阅后不再投递新消息", run: func(t *testing.T, addr string) {
+			topic := topicWithSuffix("cp7/test/conf_unsub")
+			sub := confClient(t, addr)
+			confSubscribe(t, sub, 1, topic, 1)
+			_ = sub.writePacket(&unsubscribePacket{PacketID: 2, Filters: []string{topic}})
+			mustExpectAck(t, sub, pktUNSUBACK, 2)
+			confPublish(t, confClient(t, addr), &publishPacket{QoS: 1, PacketID: 1, Topic: topic, Payload: []byte("x")})
+			expectNoPublish(t, sub, time.Second)
+		}},
+		{id: "MQTT-3.10.4-5", desc: "没有匹配订阅时也必须回复 UNSUBACK (相同标识符)", run: func(t *testing.T, addr string) {
+			conn := confClient(t, addr)
+			_ = conn.writePacket(&unsubscribePacket{PacketID: 0x1357, Filters: []string{topicWithSuffix("cp7/test/conf_never")}})
+			mustExpectAck(t, conn, pktUNSUBACK, 0x1357)
+		}},
+
+		// 3.12 PINGREQ
+		{id: "MQTT-3.12.4-1", desc: "收到 PINGREQ 必须回复 PINGRESP", run: func(t *testing.T, addr string) {
+			conn := confClient(t, addr)
+			_ = conn.writePacket(&emptyPacket{Type: pktPINGREQ})
+			if p, err := expectPacket[*emptyPacket](conn, 5*time.Second); err != nil || p.Type != pktPINGRESP {
+				t.Fatalf("waiting for PINGRESP: %v", err)
+			}
+		}},
+
+		// 4 操作行为
+		{id: "MQTT-4.3.3-2", desc: "QoS 2 接收方在 PUBREL 前收到重复 PUBLISH 不得重复分发", run: func(t *testing.T, addr string) {
+			topic := topicWithSuffix("cp7/test/conf_q2dup")
+			sub := confClient(t, addr)
+			confSubscribe(t, sub, 1, topic, 1)
+			pub := confClient(t, addr)
+			p := &publishPacket{QoS: 2, PacketID: 7, Topic: topic, Payload: []byte("once")}
+			_ = pub.writePacket(p)
+			mustExpectAck(t, pub, pktPUBREC, 7)
+			p.Dup = true
+			_ = pub.writePacket(p)
+			mustExpectAck(t, pub, pktPUBREC, 7)
+			_ = pub.writePacket(&ackPacket{Type: pktPUBREL, PacketID: 7})
+			mustExpectAck(t, pub, pktPUBCOMP, 7)
+			confExpectPublish(t, sub)
+			expectNoPublish(t, sub, time.Second)
+		}},
+		{id: "MQTT-4.4.0-1", desc: "CleanSession=0 重连后重发未确认的 PUBLISH (DUP=1)", run: func(t *testing.T, addr string) {
+			id := "conf_resend_" + randSuffix()
+			defer rawSessionCleanup(addr, id)
+			topic := topicWithSuffix("cp7/test/conf_resend")
+			sub := rawSessionConnect(t, addr, id, false)
+			confSubscribe(t, sub, 1, topic, 1)
+			confPublish(t, confClient(t, addr), &publishPacket{QoS: 1, PacketID: 1, Topic: topic, Payload: []byte("r")})
+			first, err := expectPacket[*publishPacket](sub, 5*time.Second)
+			if err != nil {
+				t.Fatalf("waiting for PUBLISH: %v", err)
+			}
+			sub.Close() // 不回 PUBACK
+			time.Sleep(300 * time.Millisecond)
+			sub = rawSessionConnect(t, addr, id, true)
+			defer sub.Close()
+			p, err := expectPacket[*publishPacket](sub, 5*time.Second)
+			if err != nil {
+				t.Fatalf("unacknowledged PUBLISH not resent: %v", err)
+			}
+			if !p.Dup || p.PacketID != first.PacketID || string(p.Payload) != "r" {
+				t.Fatalf("resent PUBLISH dup=%v id=%d payload=%q, want dup=1 id=%d", p.Dup, p.PacketID, p.Payload, first.PacketID)
+			}
+			_ = sub.writePacket(&ackPacket{Type: pktPUBACK, PacketID: p.PacketID})
+		}},
+		{id: "MQTT-4.6.0-6", desc: "同一主题上的消息按发布顺序投递", run: func(t *testing.T, addr string) {
+			topic := topicWithSuffix("cp7/test/conf_order")
+			sub := confClient(t, addr)
+			confSubscribe(t, sub, 1, topic, 1)
+			pub := confClient(t, addr)
+			for i := 0; i < 20; i++ {
+				confPublish(t, pub, &publishPacket{QoS: 1, PacketID: uint16(i + 1), Topic: topic, Payload: []byte{byte(i)}})
+			}
+			for i := 0; i < 20; i++ {
+				if p := confExpectPublish(t, sub); len(p.Payload) != 1 || p.Payload[0] != byte(i) {
+					t.Fatalf("message #%d arrived out of order: %v", i, p.Payload)
+				}
+			}
+		}},
+		{id: "MQTT-4.7.1-2", desc: "'#' 必须是过滤器的最后一个字符", malformed: true, run: func(t *testing.T, addr string) {
+			expectFilterRejected(t, addr, "cp7/test/conf/#/x")
+		}},
+		{id: "MQTT-4.7.1-3", desc: "'+' 必须占据整个层级", malformed: true, run: func(t *testing.T, addr string) {
+			expectFilterRejected(t, addr, "cp7/test/conf/a+/x")
+		}},
+		{id: "MQTT-4.7.2-1", desc: "'#' 和 '+' 不匹配以 '$' 开头的主题", run: func(t *testing.T, addr string) {
+			// 用 $SYS 主题验证：Broker 没有 $SYS 时无法判定，记为 skip
+			wild := confClient(t, addr)
+			confSubscribe(t, wild, 1, "#", 0)
+			_ = wild.writePacket(&subscribePacket{PacketID: 2, Topics: []subscription{{Filter: "+/broker/#", QoS: 0}}})
+			sys := confClient(t, addr)
+			confSubscribe(t, sys, 1, "$SYS/#", 0)
+
+			sawSys := false
+			deadline := time.Now().Add(3 * time.Second)
+			for time.Now().Before(deadline) {
+				if p, err := wild.readPacket(100 * time.Millisecond); err == nil {
+					if pp, ok := p.(*publishPacket); ok && strings.HasPrefix(pp.Topic, "$") {
+						t.Fatalf("wildcard subscription received %q", pp.Topic)
+					}
+				}
+				if p, err := sys.readPacket(100 * time.Millisecond); err == nil {
+					if _, ok := p.(*publishPacket); ok {
+						sawSys = true
+					}
+				}
+			}
+			if !sawSys {
+				t.Skip("broker published no $SYS messages")
+			}
+		}},
+		{id: "MQTT-4.7.3-1", desc: "主题名至少一个字符", malformed: true, run: func(t *testing.T, addr string) {
+			sendMalformed(t, addr, encodePacket(&publishPacket{Topic: "", Payload: []byte("x")}))
+		}},
+		{id: "MQTT-4.7.3-4", desc: "匹配时不对主题做规范化 (区分大小写)", run: func(t *testing.T, addr string) {
+			base := topicWithSuffix("cp7/test/conf_case")
+			sub := confClient(t, addr)
+			confSubscribe(t, sub, 1, base+"/Upper", 1)
+			confPublish(t, confClient(t, addr), &publishPacket{QoS: 1, PacketID: 1, Topic: base + "/upper", Payload: []byte("x")})
+			expectNoPublish(t, sub, time.Second)
+		}},
+		{id: "MQTT-1.5.3-1", desc: "非法 UTF-8 字符串视为非法报文并断开", malformed: true, run: func(t *testing.T, addr string) {
+			sendMalformed(t, addr, encodePacket(&publishPacket{Topic: "cp7/test/conf/\xff\xfe", Payload: []byte("x")}))
+		}},
+		{id: "MQTT-1.5.3-2", desc: "字符串包含 U+0000 时断开", malformed: true, run: func(t *testing.T, addr string) {
+			sendMalformed(t, addr, encodePacket(&publishPacket{Topic: "cp7/test/conf/\x00", Payload: []byte("x")}))
+		}},
+		{id: "MQTT-2.2.2-2", desc: "固定头标志位非法时断开", malformed: true, run: func(t *testing.T, addr string) {
+			sendMalformed(t, addr, encodeFrame(pktPINGREQ<<4|0x01, nil))
+		}},
+		{id: "MQTT-4.8.0-1", desc: "收到非法报文 (剩余长度超过 4 字节) 时断开", malformed: true, run: func(t *testing.T, addr string) {
+			sendMalformed(t, addr, []byte{0x30, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F})
+		}},
+	}
+}
+
+// expectFilterRejected 断言非法过滤器被拒绝：SUBACK 返回 0x80 或连接被关闭。
+func expectFilterRejected(t *testing.T, addr, filter string) {
+	t.Helper()
+	conn := confClient(t, addr)
+	_ = conn.writePacket(&subscribePacket{PacketID: 1, Topics: []subscription{{Filter: filter, QoS: 1}}})
+	p, err := conn.readPacket(5 * time.Second)
+	if err != nil {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			t.Fatal("no SUBACK and connection still open")
+		}
+		return
+	}
+	if ack, ok := p.(*subackPacket); !ok || len(ack.ReturnCodes) != 1 || ack.ReturnCodes[0] != 0x80 {
+		t.Fatalf("invalid filter %q accepted: %+v", filter, p)
+	}
+}
+
+func writeConformanceReport(t *testing.T, results []conformanceResult) {
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Result]++
+	}
+	t.Logf("conformance: %d statements, pass=%d fail=%d skip=%d", len(results), counts["pass"], counts["fail"], counts["skip"])
+
+	base := getEnv("MQTT_CONFORMANCE_REPORT", "")
+	if base == "" {
+		return
+	}
+	var md strings.Builder
+	md.WriteString("| 规范编号 | 要求 | 结果 |\n|---|---|---|\n")
+	for _, r := range results {
+		fmt.Fprintf(&md, "| %s | %s | %s |\n", r.ID, r.Desc, r.Result)
+	}
+	fmt.Fprintf(&md, "\npass %d / fail %d / skip %d\n", counts["pass"], counts["fail"], counts["skip"])
+	if err := os.WriteFile(base+".md", []byte(md.String()), 0o644); err != nil {
+		t.Errorf("write conformance report: %v", err)
+	}
+	data, _ := json.MarshalIndent(results, "", "  ")
+	if err := os.WriteFile(base+".json", append(data, '\n'), 0o644); err != nil {
+		t.Errorf("write conformance report: %v", err)
+	}
+}
+
+func TestMQTT_Conformance(t *testing.T) {
+	addr := tcpAddrFromMQTTURL(tcpEndpoint(t, mustEndpoints(t)))
+
+	malformedOK := getEnv("MQTT_CONFORMANCE_NO_BLOCKER", "") == "1"
+
+	var results []conformanceResult
+	for _, c := range conformanceCases() {
+		c := c
+		t.Run(c.id, func(t *testing.T) {
+			defer func() {
+				r := conformanceResult{ID: c.id, Desc: c.desc, Result: "pass"}
+				switch {
+				case t.Failed():
+					r.Result = "fail"
+				case t.Skipped():
+					r.Result = "skip"
+				}
+				results = append(results, r)
+			}()
+			if c.malformed && !malformedOK {
+				t.Skip("sends malformed packets that may get the test IP banned by IpBlocker; set MQTT_CONFORMANCE_NO_BLOCKER=1 to run")
+			}
+			c.run(t, addr)
+		})
+	}
+
+	writeConformanceReport(t, results)
+}
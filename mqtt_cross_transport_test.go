@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// 跨传输层投递矩阵：对同一 Broker 上 endpoint 的有序对 (A -> B)，在 A 上发布、在 B 上订阅，
+// 覆盖 QoS 0/1/2、保留消息、共享订阅和遗嘱。载荷包含全部 256 种字节值，较大的一档跨越多个
+// WebSocket 帧和 writev 批次，订阅端逐字节比较，确保各传输层的编码路径输出完全一致。
+
+// crossPayloadSizes 是每个 QoS 测试的载荷大小，第二档用奇数长度避开对齐的缓冲区边界。
+var crossPayloadSizes = []int{256, 16*1024 + 7}
+
+// crossPayload 生成以 seq 开头、其后循环填充 0x00..0xFF 的载荷。
+func crossPayload(seq uint32, size int) []byte {
+	p := make([]byte, max(size, 4))
+	binary.BigEndian.PutUint32(p, seq)
+	for i := 4; i < len(p); i++ {
+		p[i] = byte(i)
+	}
+	return p
+}
+
+// endpointPairs 返回 eps 中属于同一 Broker 实例、且 A != B 的所有有序对。
+// 不同 Broker 实例之间消息不互通，所以只在同一实例内配对。
+func endpointPairs(eps []brokerEndpoint) [][2]brokerEndpoint {
+	var pairs [][2]brokerEndpoint
+	for _, a := range eps {
+		for _, b := range eps {
+			if a.name != b.name && a.broker == b.broker {
+				pairs = append(pairs, [2]brokerEndpoint{a, b})
+			}
+		}
+	}
+	return pairs
+}
+
+// mixedTransportPairs 补齐明文与 TLS 之间的配对。Broker 只有一个 TCP 监听 (host) 和一个 WebSocket
+// 监听 (websocket_host)，配置了证书的监听器只提供 TLS，因此本地的明文 Broker (tcp/ws) 和 TLS Broker
+// (ssl/wss) 之间无法配对。这里另起两个混合配置的 Broker：tcp + wss 与 ssl + ws，覆盖 tcp<->wss 和
+// ws<->ssl；tcp<->ssl 与 ws<->wss 需要同一实例上同类型的两个监听器，Broker 不支持，无法测试。
+// 仅在使用本地 Broker 且生成了测试证书时可用。
+func mixedTransportPairs(t *testing.T) [][2]brokerEndpoint {
+	t.Helper()
+	f := testTLSFixture
+	if externalBroker() || f == nil {
+		return nil
+	}
+	var pairs [][2]brokerEndpoint
+	for _, configure := range []func(*brokerConfig){
+		func(cfg *brokerConfig) { cfg.WebsocketTLSPem, cfg.WebsocketTLSKey = f.serverPem, f.serverKey },
+		func(cfg *brokerConfig) { cfg.TCPTLSPem, cfg.TCPTLSKey = f.serverPem, f.serverKey },
+	} {
+		eps, err := startTestBroker(t, configure)
+		if err != nil {
+			t.Logf("mixed plain/TLS broker unavailable, skipping its pairs: %v", err)
+			continue
+		}
+		pairs = append(pairs, endpointPairs(eps)...)
+	}
+	return pairs
+}
+
+// dialTransport 按 URL 协议建立底层连接，与 paho 内置的拨号逻辑一致。
+func dialTransport(uri *url.URL, o mqtt.ClientOptions) (net.Conn, error) {
+	switch uri.Scheme {
+	case "ws", "wss":
+		var tlsc *tls.Config
+		if uri.Scheme == "wss" {
+			tlsc = o.TLSConfig
+		}
+		u := *uri
+		u.User = nil
+		return mqtt.NewWebsocket(u.String(), tlsc, o.ConnectTimeout, o.HTTPHeaders, o.WebsocketOptions)
+	case "ssl", "tls", "mqtts", "tcps":
+		return tls.DialWithDialer(&net.Dialer{Timeout: o.ConnectTimeout}, "tcp", uri.Host, o.TLSConfig)
+	default:
+		return net.DialTimeout("tcp", uri.Host, o.ConnectTimeout)
+	}
+}
+
+// connectKillable 连接 paho 客户端并返回一个函数，用于直接关闭底层连接 (不发送 DISCONNECT)，
+// 以便在任意传输层上模拟异常断开。
+func connectKillable(t *testing.T, opts *mqtt.ClientOptions) (mqtt.Client, func()) {
+	t.Helper()
+	var mu sync.Mutex
+	var conn net.Conn
+	opts.SetCustomOpenConnectionFn(func(uri *url.URL, o mqtt.ClientOptions) (net.Conn, error) {
+		c, err := dialTransport(uri, o)
+		mu.Lock()
+		conn = c
+		mu.Unlock()
+		return c, err
+	})
+	c := mqtt.NewClient(opts)
+	mustConnect(t, c, 5*time.Second)
+	return c, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+func testCrossTransport(t *testing.T, eps []brokerEndpoint) {
+	pairs := append(endpointPairs(eps), mixedTransportPairs(t)...)
+	if len(pairs) == 0 {
+		t.Skip("cross-transport matrix needs at least two endpoints")
+	}
+
+	for _, pair := range pairs {
+		from, to := pair[0], pair[1]
+		t.Run(from.name+"_to_"+to.name, func(t *testing.T) {
+			for qos := byte(0); qos <= 2; qos++ {
+				for _, size := range crossPayloadSizes {
+					qos, size := qos, size
+					t.Run("QoS"+strconv.Itoa(int(qos))+"_"+strconv.Itoa(size)+"B", func(t *testing.T) {
+						topic := topicWithSuffix("cp7/test/cross")
+						want := crossPayload(uint32(size), size)
+
+						got := make(chan []byte, 4)
+						sub := createClient(to.url, to.name+"_xsub_"+randSuffix(), true)
+						mustConnect(t, sub, 5*time.Second)
+						defer sub.Disconnect(250)
+						mustWaitToken(t, sub.Subscribe(topic, qos, func(client mqtt.Client, msg mqtt.Message) {
+							got <- msg.Payload()
+						}), 5*time.Second, "subscribe")
+
+						pub := createClient(from.url, from.name+"_xpub_"+randSuffix(), true)
+						mustConnect(t, pub, 5*time.Second)
+						defer pub.Disconnect(250)
+						mustWaitToken(t, pub.Publish(topic, qos, false, want), 10*time.Second, "publish")
+
+						select {
+						case p := <-got:
+							if !bytes.Equal(p, want) {
+								t.Fatalf("%s -> %s payload differs: got %d bytes, want %d", from.name, to.name, len(p), len(want))
+							}
+						case <-time.After(10 * time.Second):
+							t.Fatalf("%s -> %s QoS%d message not received", from.name, to.name, qos)
+						}
+						if qos == 2 {
+							select {
+							case <-got:
+								t.Fatal("QoS2 message delivered twice")
+							case <-time.After(300 * time.Millisecond):
+							}
+						}
+					})
+				}
+			}
+
+			t.Run("Retain", func(t *testing.T) {
+				topic := topicWithSuffix("cp7/test/cross_retain")
+				want := crossPayload(1, 1024)
+
+				pub := createClient(from.url, from.name+"_xretpub_"+randSuffix(), true)
+				mustConnect(t, pub, 5*time.Second)
+				defer func() {
+					pub.Publish(topic, 1, true, "").WaitTimeout(2 * time.Second)
+					pub.Disconnect(250)
+				}()
+				mustWaitToken(t, pub.Publish(topic, 1, true, want), 10*time.Second, "publish retain")
+
+				got := make(chan mqtt.Message, 1)
+				sub := createClient(to.url, to.name+"_xretsub_"+randSuffix(), true)
+				mustConnect(t, sub, 5*time.Second)
+				defer sub.Disconnect(250)
+				mustWaitToken(t, sub.Subscribe(topic, 1, func(client mqtt.Client, msg mqtt.Message) {
+					select {
+					case got <- msg:
+					default:
+					}
+				}), 5*time.Second, "subscribe")
+
+				select {
+				case msg := <-got:
+					if !msg.Retained() {
+						t.Error("retained message delivered without RETAIN flag")
+					}
+					if !bytes.Equal(msg.Payload(), want) {
+						t.Fatalf("retained payload differs: got %d bytes, want %d", len(msg.Payload()), len(want))
+					}
+				case <-time.After(10 * time.Second):
+					t.Fatal("retained message not received")
+				}
+			})
+
+			t.Run("SharedSubscription", func(t *testing.T) {
+				// 同组的两个订阅者分别位于 A 和 B，每条消息只应被组内一个成员收到一次
+				const n = 20
+				topic := topicWithSuffix("cp7/test/cross_shared")
+				filter := "$share/g_cross_" + randSuffix() + "/" + topic
+
+				var mu sync.Mutex
+				seen := make(map[uint32]int)
+				corrupt := 0
+				handler := func(client mqtt.Client, msg mqtt.Message) {
+					p := msg.Payload()
+					mu.Lock()
+					defer mu.Unlock()
+					if len(p) < 4 {
+						corrupt++
+						return
+					}
+					seq := binary.BigEndian.Uint32(p)
+					if !bytes.Equal(p, crossPayload(seq, 512)) {
+						corrupt++
+					}
+					seen[seq]++
+				}
+				for _, ep := range []brokerEndpoint{from, to} {
+					c := createClient(ep.url, ep.name+"_xshare_"+randSuffix(), true)
+					mustConnect(t, c, 5*time.Second)
+					defer c.Disconnect(250)
+					mustWaitToken(t, c.Subscribe(filter, 1, handler), 5*time.Second, "subscribe "+ep.name)
+				}
+
+				pub := createClient(from.url, from.name+"_xsharepub_"+randSuffix(), true)
+				mustConnect(t, pub, 5*time.Second)
+				defer pub.Disconnect(250)
+				for i := uint32(0); i < n; i++ {
+					mustWaitToken(t, pub.Publish(topic, 1, false, crossPayload(i, 512)), 10*time.Second, "publish")
+				}
+
+				deadline := time.Now().Add(10 * time.Second)
+				for time.Now().Before(deadline) {
+					mu.Lock()
+					done := len(seen) == n
+					mu.Unlock()
+					if done {
+						break
+					}
+					time.Sleep(50 * time.Millisecond)
+				}
+				time.Sleep(300 * time.Millisecond)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if corrupt > 0 {
+					t.Errorf("%d shared messages arrived with a different payload", corrupt)
+				}
+				if len(seen) != n {
+					t.Fatalf("shared group received %d/%d distinct messages", len(seen), n)
+				}
+				for seq, c := range seen {
+					if c > 1 {
+						t.Errorf("message #%d delivered %d times within the shared group", seq, c)
+					}
+				}
+			})
+
+			t.Run("LWT", func(t *testing.T) {
+				// 遗嘱客户端连在 A 上并异常断开，B 上的订阅者应收到逐字节一致的遗嘱
+				willTopic := "will/cross/" + randSuffix()
+				want := crossPayload(7, 300)
+
+				got := make(chan []byte, 1)
+				sub := createClient(to.url, to.name+"_xwillsub_"+randSuffix(), true)
+				mustConnect(t, sub, 5*time.Second)
+				defer sub.Disconnect(250)
+				mustWaitToken(t, sub.Subscribe(willTopic, 1, func(client mqtt.Client, msg mqtt.Message) {
+					select {
+					case got <- msg.Payload():
+					default:
+					}
+				}), 5*time.Second, "subscribe")
+
+				opts := newClientOptions(from.url, from.name+"_xwill_"+randSuffix(), true)
+				opts.SetBinaryWill(willTopic, want, 1, false)
+				_, kill := connectKillable(t, opts)
+				kill()
+
+				select {
+				case p := <-got:
+					if !bytes.Equal(p, want) {
+						t.Fatalf("will payload differs: got %d bytes, want %d", len(p), len(want))
+					}
+				case <-time.After(10 * time.Second):
+					t.Fatalf("LWT from %s not received on %s", from.name, to.name)
+				}
+			})
+		})
+	}
+}
@@ -15,8 +15,9 @@ import (
 )
 
 type brokerEndpoint struct {
-	name string
-	url  string
+	name   string
+	url    string
+	broker string // 所属 Broker 实例，同一实例的 endpoint 共享会话与订阅；外部 Broker 为空
 }
 
 func getEnv(key, def string) string {
@@ -37,15 +38,72 @@ func getEnvInt(t *testing.T, key string, def int) int {
 	return def
 }
 
+// endpointsFromEnv 优先使用 MQTT_TCP_URL/MQTT_WS_URL (以及 MQTT_SSL_URL/MQTT_WSS_URL) 指定的外部 Broker，
+// 否则使用 TestMain 拉起的本地 Broker (含 TLS Broker)。两者都没有时返回空。
 func endpointsFromEnv() []brokerEndpoint {
-	tcp := getEnv("MQTT_TCP_URL", "tcp://cp7.xbyct.net:1883")
-	ws := getEnv("MQTT_WS_URL", "ws://cp7.xbyct.net:8083")
-	return []brokerEndpoint{
-		{name: "tcp", url: tcp},
-		{name: "ws", url: ws},
+	if !externalBroker() {
+		var eps []brokerEndpoint
+		for _, b := range []*brokerProcess{localBroker, localTLSBroker} {
+			if b != nil {
+				eps = append(eps, b.endpoints()...)
+			}
+		}
+		return eps
+	}
+	var eps []brokerEndpoint
+	for _, e := range []struct{ name, key string }{
+		{"tcp", "MQTT_TCP_URL"},
+		{"ws", "MQTT_WS_URL"},
+		{"ssl", "MQTT_SSL_URL"},
+		{"wss", "MQTT_WSS_URL"},
+	} {
+		if u := getEnv(e.key, ""); u != "" {
+			eps = append(eps, brokerEndpoint{name: e.name, url: u})
+		}
+	}
+	return eps
+}
+
+// externalBroker 判断是否通过环境变量指定了外部 Broker。
+func externalBroker() bool {
+	for _, k := range []string{"MQTT_TCP_URL", "MQTT_WS_URL", "MQTT_SSL_URL", "MQTT_WSS_URL"} {
+		if getEnv(k, "") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// mustEndpoints 返回可用的 Broker 地址，没有可用 Broker 时跳过测试。
+func mustEndpoints(t *testing.T) []brokerEndpoint {
+	t.Helper()
+	eps := endpointsFromEnv()
+	if len(eps) == 0 {
+		t.Skip("no broker: set MQTT_BROKER_BIN (or put ApexMQTT in PATH), or MQTT_TCP_URL/MQTT_WS_URL")
+	}
+	return eps
+}
+
+// tcpEndpoint 返回 TCP 地址，仅配置了 WebSocket 时跳过测试。
+func tcpEndpoint(t *testing.T, eps []brokerEndpoint) string {
+	t.Helper()
+	for _, ep := range eps {
+		if ep.name == "tcp" {
+			return ep.url
+		}
 	}
+	t.Skip("no tcp endpoint configured")
+	return ""
 }
 
+// testRunID 标识本次测试运行，topicWithSuffix 生成的主题都带有它，TestMain 结束前的泄漏检查据此
+// 找出本次运行遗留的保留消息。
+var testRunID = randSuffix()
+
+// persistentSessions 记录本次运行中以 CleanSession=false 连接过的 ClientId (-> Broker URL)，
+// 结束时的泄漏检查确认这些会话都已被清理。
+var persistentSessions sync.Map
+
 func randSuffix() string {
 	var b [4]byte
 	_, _ = rand.Read(b[:])
@@ -56,7 +114,14 @@ func topicWithSuffix(prefix string) string {
 	if !strings.HasSuffix(prefix, "/") {
 		prefix += "/"
 	}
-	return prefix + randSuffix()
+	return prefix + testRunID + "_" + randSuffix()
+}
+
+// parallel 把子测试标记为并行执行；MQTT_SERIAL=1 时保持串行，便于排查问题或测量延迟。
+func parallel(t *testing.T) {
+	if getEnv("MQTT_SERIAL", "") != "1" {
+		t.Parallel()
+	}
 }
 
 func newClientOptions(brokerURL, clientID string, clean bool) *mqtt.ClientOptions {
@@ -64,8 +129,18 @@ func newClientOptions(brokerURL, clientID string, clean bool) *mqtt.ClientOption
 	opts.AddBroker(brokerURL)
 	opts.SetClientID(clientID)
 	opts.SetCleanSession(clean)
+	if !clean {
+		persistentSessions.Store(clientID, brokerURL)
+	}
 	opts.SetConnectTimeout(5 * time.Second)
 	opts.SetAutoReconnect(false)
+	creds, _ := testCredentials()
+	user, pass := creds.forClient(clientID)
+	opts.SetUsername(user)
+	opts.SetPassword(pass)
+	if isTLSURL(brokerURL) {
+		opts.SetTLSConfig(tlsClientConfig())
+	}
 	return opts
 }
 
@@ -106,18 +181,21 @@ func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
 }
 
 func TestMQTT_Functional_Full(t *testing.T) {
-	eps := endpointsFromEnv()
+	eps := mustEndpoints(t)
 
 	for _, ep := range eps {
 		ep := ep
 		t.Run(ep.name, func(t *testing.T) {
+			parallel(t)
 			t.Run("ConnectAndDisconnect", func(t *testing.T) {
+				parallel(t)
 				c := createClient(ep.url, ep.name+"_basic_"+randSuffix(), true)
 				mustConnect(t, c, 5*time.Second)
 				c.Disconnect(250)
 			})
 
 			t.Run("PubSub_QoS0", func(t *testing.T) {
+				parallel(t)
 				c := createClient(ep.url, ep.name+"_qos0_"+randSuffix(), true)
 				mustConnect(t, c, 5*time.Second)
 				defer c.Disconnect(250)
@@ -141,6 +219,7 @@ func TestMQTT_Functional_Full(t *testing.T) {
 			})
 
 			t.Run("PubSub_QoS1", func(t *testing.T) {
+				parallel(t)
 				c := createClient(ep.url, ep.name+"_qos1_"+randSuffix(), true)
 				mustConnect(t, c, 5*time.Second)
 				defer c.Disconnect(250)
@@ -164,6 +243,7 @@ func TestMQTT_Functional_Full(t *testing.T) {
 			})
 
 			t.Run("PubSub_QoS2", func(t *testing.T) {
+				parallel(t)
 				c := createClient(ep.url, ep.name+"_qos2_"+randSuffix(), true)
 				mustConnect(t, c, 5*time.Second)
 				defer c.Disconnect(250)
@@ -186,52 +266,62 @@ func TestMQTT_Functional_Full(t *testing.T) {
 				}
 			})
 
+			t.Run("Ordering", func(t *testing.T) {
+				parallel(t)
+				testOrdering(t, ep)
+			})
+
 			t.Run("Wildcard_Plus", func(t *testing.T) {
+				parallel(t)
 				c := createClient(ep.url, ep.name+"_wcplus_"+randSuffix(), true)
 				mustConnect(t, c, 5*time.Second)
 				defer c.Disconnect(250)
 
+				base := topicWithSuffix("cp7/test/wcplus")
 				wg := sync.WaitGroup{}
 				wg.Add(1)
 				var once sync.Once
 
-				mustWaitToken(t, c.Subscribe("a/+/c", 0, func(client mqtt.Client, msg mqtt.Message) {
-					if msg.Topic() == "a/b/c" {
+				mustWaitToken(t, c.Subscribe(base+"/+/c", 0, func(client mqtt.Client, msg mqtt.Message) {
+					if msg.Topic() == base+"/b/c" {
 						once.Do(func() { wg.Done() })
 					}
 				}), 5*time.Second, "subscribe")
 
-				c.Publish("a/b/c", 0, false, "match")
+				c.Publish(base+"/b/c", 0, false, "match")
 				if waitTimeout(&wg, 3*time.Second) {
 					t.Fatal("Wildcard + failed")
 				}
 			})
 
 			t.Run("Wildcard_Hash", func(t *testing.T) {
+				parallel(t)
 				c := createClient(ep.url, ep.name+"_wchash_"+randSuffix(), true)
 				mustConnect(t, c, 5*time.Second)
 				defer c.Disconnect(250)
 
+				base := topicWithSuffix("cp7/test/wchash")
 				wg := sync.WaitGroup{}
 				wg.Add(2)
 				var m1, m2 sync.Once
 
-				mustWaitToken(t, c.Subscribe("a/#", 0, func(client mqtt.Client, msg mqtt.Message) {
-					if msg.Topic() == "a/b/c" {
+				mustWaitToken(t, c.Subscribe(base+"/#", 0, func(client mqtt.Client, msg mqtt.Message) {
+					if msg.Topic() == base+"/b/c" {
 						m1.Do(func() { wg.Done() })
-					} else if msg.Topic() == "a/d" {
+					} else if msg.Topic() == base+"/d" {
 						m2.Do(func() { wg.Done() })
 					}
 				}), 5*time.Second, "subscribe")
 
-				c.Publish("a/b/c", 0, false, "match1")
-				c.Publish("a/d", 0, false, "match2")
+				c.Publish(base+"/b/c", 0, false, "match1")
+				c.Publish(base+"/d", 0, false, "match2")
 				if waitTimeout(&wg, 3*time.Second) {
 					t.Fatal("Wildcard # failed")
 				}
 			})
 
 			t.Run("Unsubscribe", func(t *testing.T) {
+				parallel(t)
 				c := createClient(ep.url, ep.name+"_unsub_"+randSuffix(), true)
 				mustConnect(t, c, 5*time.Second)
 				defer c.Disconnect(250)
@@ -253,6 +343,7 @@ func TestMQTT_Functional_Full(t *testing.T) {
 			})
 
 			t.Run("Retain", func(t *testing.T) {
+				parallel(t)
 				topic := topicWithSuffix("cp7/test/retain")
 				payload := "retained_" + randSuffix()
 
@@ -290,6 +381,7 @@ func TestMQTT_Functional_Full(t *testing.T) {
 			})
 
 			t.Run("SharedSubscription_Basic", func(t *testing.T) {
+				parallel(t)
 				topic := topicWithSuffix("cp7/test/shared_basic")
 				filter := "$share/g_basic/" + topic
 
@@ -330,8 +422,9 @@ func TestMQTT_Functional_Full(t *testing.T) {
 			})
 
 			t.Run("Wildcard_Mix_Exact", func(t *testing.T) {
+				parallel(t)
 				// 验证通配符路径和精确路径共存时的正确性
-				base := "mix/" + randSuffix()
+				base := topicWithSuffix("cp7/test/mix")
 				exactTopic := base + "/a/b"
 				wildcardTopic := base + "/+/b"
 
@@ -363,9 +456,11 @@ func TestMQTT_Functional_Full(t *testing.T) {
 			})
 
 			t.Run("Overlapping_Subscription_QoS", func(t *testing.T) {
+				parallel(t)
 				// 协议规范 3.3.4: 重叠订阅时发送单条消息，且 QoS 为所有匹配中的最高值
-				topic := topicWithSuffix("overlap/" + randSuffix())
-				wildcard := "overlap/#"
+				base := topicWithSuffix("cp7/test/overlap")
+				topic := base + "/x"
+				wildcard := base + "/#"
 
 				cSub := createClient(ep.url, ep.name+"_ovsub_"+randSuffix(), true)
 				mustConnect(t, cSub, 5*time.Second)
@@ -404,6 +499,7 @@ func TestMQTT_Functional_Full(t *testing.T) {
 			})
 
 			t.Run("Invalid_Topic_Filters", func(t *testing.T) {
+				parallel(t)
 				// 验证非法主题过滤器被拒绝 (SUBACK 0x80)
 				c := createClient(ep.url, ep.name+"_invsub_"+randSuffix(), true)
 				mustConnect(t, c, 5*time.Second)
@@ -434,8 +530,9 @@ func TestMQTT_Functional_Full(t *testing.T) {
 			})
 
 			t.Run("Retain_Wildcard_Match", func(t *testing.T) {
+				parallel(t)
 				// 验证通配符订阅能匹配多个已存在的保留消息
-				base := "retain_wc/" + randSuffix()
+				base := topicWithSuffix("cp7/test/retain_wc")
 				t1 := base + "/a"
 				t2 := base + "/b"
 				payload := "val"
@@ -476,6 +573,7 @@ func TestMQTT_Functional_Full(t *testing.T) {
 			})
 
 			t.Run("SystemTopic_NoCrossMatch", func(t *testing.T) {
+				parallel(t)
 				// 验证 $SYS 主题不会被普通的 # 或 + 匹配到
 				cSub := createClient(ep.url, ep.name+"_syssub_"+randSuffix(), true)
 				mustConnect(t, cSub, 5*time.Second)
@@ -514,6 +612,7 @@ func TestMQTT_Functional_Full(t *testing.T) {
 			})
 
 			t.Run("SystemTopic_Functionality", func(t *testing.T) {
+				parallel(t)
 				// 验证 $SYS 主题能够正确返回数据 (依赖于 Retain 机制)
 				c := createClient(ep.url, ep.name+"_sysfunc_"+randSuffix(), true)
 				mustConnect(t, c, 5*time.Second)
@@ -552,9 +651,11 @@ func TestMQTT_Functional_Full(t *testing.T) {
 
 	// TCP-only: persistent session behavior
 	t.Run("TCP_Only", func(t *testing.T) {
-		tcp := eps[0].url
+		parallel(t)
+		tcp := tcpEndpoint(t, eps)
 
 		t.Run("ClientID_Conflict_Kick", func(t *testing.T) {
+			parallel(t)
 			id := "conflict_" + randSuffix()
 			c1 := createClient(tcp, id, true)
 			mustConnect(t, c1, 5*time.Second)
@@ -570,7 +671,9 @@ func TestMQTT_Functional_Full(t *testing.T) {
 		})
 
 		t.Run("CleanSession_False_Persistence", func(t *testing.T) {
+			parallel(t)
 			clientID := "persist_" + randSuffix()
+			cleanupSession(t, tcp, clientID)
 			topic := topicWithSuffix("cp7/test/session")
 			payload := "offline_" + randSuffix()
 
@@ -601,7 +704,23 @@ func TestMQTT_Functional_Full(t *testing.T) {
 			}
 		})
 
+		t.Run("Persistent_Session", func(t *testing.T) {
+			parallel(t)
+			testPersistentSession(t, tcp, tcp, tcp)
+		})
+
+		t.Run("QoS2_Handshake_Interrupted", func(t *testing.T) {
+			parallel(t)
+			testQoS2Interrupted(t, tcp)
+		})
+
+		t.Run("KeepAlive", func(t *testing.T) {
+			parallel(t)
+			testKeepAlive(t, tcp)
+		})
+
 		t.Run("LWT_Abnormal_Disconnect", func(t *testing.T) {
+			parallel(t)
 			// 验证异常断开时遗嘱消息的触发 (协议 Section 3.1.2.5)
 			willTopic := "will/status/" + randSuffix()
 			willPayload := "offline"
@@ -621,32 +740,19 @@ func TestMQTT_Functional_Full(t *testing.T) {
 
 			// 2. 模拟一个崩溃的客户端：连接并发送 Will，然后强制关闭 TCP
 			addr := tcpAddrFromMQTTURL(tcp)
-			conn, err := tcpDial(addr, 5*time.Second)
+			conn, ack, err := rawConnect(addr, &connectPacket{
+				CleanSession: true,
+				KeepAlive:    60,
+				ClientID:     "crash_client_" + randSuffix(),
+				WillFlag:     true,
+				WillTopic:    willTopic,
+				WillMessage:  []byte(willPayload),
+			}, 5*time.Second)
 			if err != nil {
-				t.Fatalf("dial error: %v", err)
+				t.Fatalf("CONNECT failed or timeout: %v", err)
 			}
-
-			// 构造 CONNECT 包 (包含 Will)
-			cid := "crash_client_" + randSuffix()
-
-			var pkt []byte
-			// 协议名 "MQTT" (0x00 0x04 'M' 'Q' 'T' 'T')
-			pkt = append(pkt, 0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04, 0x06, 0x00, 0x3C)
-			// Payload: ClientID, WillTopic, WillMessage
-			pkt = append(pkt, uint8(len(cid)>>8), uint8(len(cid)&0xFF))
-			pkt = append(pkt, []byte(cid)...)
-			pkt = append(pkt, uint8(len(willTopic)>>8), uint8(len(willTopic)&0xFF))
-			pkt = append(pkt, []byte(willTopic)...)
-			pkt = append(pkt, uint8(len(willPayload)>>8), uint8(len(willPayload)&0xFF))
-			pkt = append(pkt, []byte(willPayload)...)
-
-			// 写入 CONNECT (带剩余长度，假设 < 127)
-			_, _ = conn.Write(append([]byte{0x10, uint8(len(pkt))}, pkt...))
-
-			// 等待 CONNACK
-			resp, err := mustReadSome(conn, 2*time.Second)
-			if err != nil || len(resp) < 4 || resp[3] != 0x00 {
-				t.Fatalf("CONNACK failed or timeout: %v (resp: %v)", err, resp)
+			if ack.ReturnCode != 0x00 {
+				t.Fatalf("CONNACK refused: return code %#x", ack.ReturnCode)
 			}
 
 			// 3. 核心步骤：直接物理关闭 TCP 连接，不发 DISCONNECT 报文
@@ -659,6 +765,7 @@ func TestMQTT_Functional_Full(t *testing.T) {
 		})
 
 		t.Run("LWT_Normal_Disconnect", func(t *testing.T) {
+			parallel(t)
 			// 验证正常断开连接时遗嘱消息不应触发 (协议 Section 3.1.2.5)
 			willTopic := "will/normal/" + randSuffix()
 			willPayload := "should_not_see_this"
@@ -690,6 +797,7 @@ func TestMQTT_Functional_Full(t *testing.T) {
 		})
 
 		t.Run("LWT_Normal_Disconnect_Immediate_Close", func(t *testing.T) {
+			parallel(t)
 			// 极端测试：使用原生 TCP 发送 DISCONNECT 后立即物理关闭连接
 			// 用于验证 pollio 优先级和 OnDisconnect 缓冲区排空逻辑
 			willTopic := "will/race/" + randSuffix()
@@ -705,30 +813,25 @@ func TestMQTT_Functional_Full(t *testing.T) {
 				received.Store(true)
 			}), 5*time.Second, "sub")
 
-			// 2. 通过原生 TCP 模拟极限行为
+			// 2. 通过原生 TCP 模拟极限行为，等待 CONNACK 确保服务端已解析
 			addr := tcpAddrFromMQTTURL(tcp)
-			conn, err := tcpDial(addr, 5*time.Second)
+			conn, ack, err := rawConnect(addr, &connectPacket{
+				CleanSession: true,
+				KeepAlive:    60,
+				ClientID:     "race_client_" + randSuffix(),
+				WillFlag:     true,
+				WillTopic:    willTopic,
+				WillMessage:  []byte(willPayload),
+			}, 5*time.Second)
 			if err != nil {
-				t.Fatalf("dial error: %v", err)
+				t.Fatalf("CONNECT failed or timeout: %v", err)
+			}
+			if ack.ReturnCode != 0x00 {
+				t.Fatalf("CONNACK refused: return code %#x", ack.ReturnCode)
 			}
 
-			cid := "race_client_" + randSuffix()
-			// CONNECT 报文 (带 Will)
-			var pkt []byte
-			pkt = append(pkt, 0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04, 0x06, 0x00, 0x3C)
-			pkt = append(pkt, uint8(len(cid)>>8), uint8(len(cid)&0xFF))
-			pkt = append(pkt, []byte(cid)...)
-			pkt = append(pkt, uint8(len(willTopic)>>8), uint8(len(willTopic)&0xFF))
-			pkt = append(pkt, []byte(willTopic)...)
-			pkt = append(pkt, uint8(len(willPayload)>>8), uint8(len(willPayload)&0xFF))
-			pkt = append(pkt, []byte(willPayload)...)
-			_, _ = conn.Write(append([]byte{0x10, uint8(len(pkt))}, pkt...))
-
-			// 等待 CONNACK 确保服务端已解析
-			_, _ = mustReadSome(conn, 2*time.Second)
-
-			// 3. 极限操作：发送 DISCONNECT (0xE0, 0x00) 并立即 Close
-			_, _ = conn.Write([]byte{0xE0, 0x00})
+			// 3. 极限操作：发送 DISCONNECT 并立即 Close
+			_ = conn.writePacket(&emptyPacket{Type: pktDISCONNECT})
 			conn.Close() // 立即物理断开，模拟网络事件与数据包同时到达
 
 			// 4. 验证遗嘱不应被触发
@@ -739,8 +842,10 @@ func TestMQTT_Functional_Full(t *testing.T) {
 		})
 
 		t.Run("Session_Recovery_QoS2", func(t *testing.T) {
+			parallel(t)
 			// 验证 QoS 2 在 CleanSession=0 时的会话恢复能力
 			clientID := "q2persist_" + randSuffix()
+			cleanupSession(t, tcp, clientID)
 			topic := topicWithSuffix("cp7/test/q2persist")
 			payload := "q2_offline_" + randSuffix()
 
@@ -775,188 +880,34 @@ func TestMQTT_Functional_Full(t *testing.T) {
 		})
 	})
 
-	// Delivery minimum (TCP+WS). Default is 64KB; tune with MQTT_DELIVER_MIN.
-	//t.Run("Payload_Delivery_Min", func(t *testing.T) {
-	//	minBytes := getEnvInt(t, "MQTT_DELIVER_MIN", 64*1024)
-	//	topic := topicWithSuffix("cp7/test/deliver_min")
-	//
-	//	for _, ep := range eps {
-	//		ep := ep
-	//		t.Run(ep.name, func(t *testing.T) {
-	//			deliverOnce := func(size int) (bool, string) {
-	//				subLost := make(chan error, 1)
-	//				subOpts := newClientOptions(ep.url, ep.name+"_dsub_"+randSuffix(), true)
-	//				subOpts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
-	//					select {
-	//					case subLost <- err:
-	//					default:
-	//					}
-	//				})
-	//				sub := mqtt.NewClient(subOpts)
-	//				mustConnect(t, sub, 5*time.Second)
-	//				defer sub.Disconnect(250)
-	//
-	//				wg := sync.WaitGroup{}
-	//				wg.Add(1)
-	//				gotLenCh := make(chan int, 1)
-	//				var once sync.Once
-	//				mustWaitToken(t, sub.Subscribe(topic, 1, func(client mqtt.Client, msg mqtt.Message) {
-	//					once.Do(func() {
-	//						gotLenCh <- len(msg.Payload())
-	//						wg.Done()
-	//					})
-	//				}), 5*time.Second, "subscribe")
-	//
-	//				pub := createClient(ep.url, ep.name+"_dpub_"+randSuffix(), true)
-	//				mustConnect(t, pub, 5*time.Second)
-	//				defer pub.Disconnect(250)
-	//
-	//				payload := make([]byte, size)
-	//				for i := range payload {
-	//					payload[i] = byte(i)
-	//				}
-	//				tok := pub.Publish(topic, 1, false, payload)
-	//				if !tok.WaitTimeout(30*time.Second) || tok.Error() != nil {
-	//					if tok.Error() != nil {
-	//						return false, "publish error: " + tok.Error().Error()
-	//					}
-	//					return false, "publish timeout"
-	//				}
-	//
-	//				if waitTimeout(&wg, 30*time.Second) {
-	//					select {
-	//					case err := <-subLost:
-	//						return false, "subscriber lost connection: " + err.Error()
-	//					default:
-	//					}
-	//					return false, "subscriber did not receive message"
-	//				}
-	//				got := <-gotLenCh
-	//				if got != size {
-	//					return false, "payload length mismatch"
-	//				}
-	//				return true, ""
-	//			}
-	//
-	//			ok, why := deliverOnce(minBytes)
-	//			if ok {
-	//				return
-	//			}
-	//
-	//			// Probe downwards with fresh connections to avoid contaminating state.
-	//			candidates := []int{minBytes / 2, minBytes / 4, minBytes / 8, 64 * 1024, 32 * 1024, 16 * 1024, 8 * 1024}
-	//			best := 0
-	//			for _, sz := range candidates {
-	//				if sz <= 0 {
-	//					continue
-	//				}
-	//				ok2, _ := deliverOnce(sz)
-	//				if ok2 {
-	//					best = sz
-	//					break
-	//				}
-	//			}
-	//			t.Fatalf("payload %d not delivered on %s (%s). Max deliverable appears <= %d bytes. Tune TCP listener write-buffer/backpressure if you require larger delivery.",
-	//				minBytes, ep.name, why, best)
-	//		})
-	//	}
-	//})
-
-	//// Max packet size policy (TCP). Default max is 1MB.
-	//t.Run("MaxPacketSize", func(t *testing.T) {
-	//	maxPacket := getEnvInt(t, "MQTT_MAX_PACKET", 1024*1024)
-	//	tcp := eps[0].url
-	//
-	//	t.Run("QoS1_Accept_JustUnder", func(t *testing.T) {
-	//		topic := "cp7/test/maxpkt"
-	//		pub := createClient(tcp, "maxpkt_pub_"+randSuffix(), true)
-	//		mustConnect(t, pub, 5*time.Second)
-	//		defer pub.Disconnect(250)
-	//
-	//		// Binary search the largest payload size that completes QoS1 publish.
-	//		buf := make([]byte, maxPacket)
-	//		for i := range buf {
-	//			buf[i] = byte(i)
-	//		}
-	//
-	//		lo, hi := 0, len(buf)
-	//		best := 0
-	//		for lo <= hi {
-	//			mid := (lo + hi) / 2
-	//			tok := pub.Publish(topic, 1, false, buf[:mid])
-	//			if tok.WaitTimeout(5*time.Second) && tok.Error() == nil {
-	//				best = mid
-	//				lo = mid + 1
-	//			} else {
-	//				hi = mid - 1
-	//			}
-	//		}
-	//		if best == 0 {
-	//			t.Fatal("cannot publish any payload under max packet size (unexpected)")
-	//		}
-	//	})
-	//
-	//	t.Run("OverLimit_Rejected_OptIn", func(t *testing.T) {
-	//		if os.Getenv("MQTT_TEST_OVERLIMIT") != "1" {
-	//			t.Skip("set MQTT_TEST_OVERLIMIT=1 to run over-limit test (may affect IP blocking policies)")
-	//		}
-	//		pub := createClient(tcp, "overlimit_pub_"+randSuffix(), true)
-	//		mustConnect(t, pub, 5*time.Second)
-	//		defer pub.Disconnect(250)
-	//
-	//		over := make([]byte, maxPacket+2048)
-	//		tok := pub.Publish("cp7/test/overlimit", 1, false, over)
-	//		if tok.WaitTimeout(10*time.Second) && tok.Error() == nil {
-	//			t.Fatal("expected publish rejection for oversize packet")
-	//		}
-	//	})
-	//})
-
-	// Protocol validation / failure-mode tests (raw TCP, no paho)
-	// 注释掉可能会导致 IP 被封禁的非法协议测试
-	/*
-		t.Run("Protocol_InvalidPackets_TCP", func(t *testing.T) {
-			tcpURL := eps[0].url
-			addr := tcpAddrFromMQTTURL(tcpURL)
-
-			t.Run("Invalid_CONNECT_RemainingLengthVarint", func(t *testing.T) {
-				conn, err := tcpDial(addr, 3*time.Second)
-				if err != nil {
-					t.Fatalf("dial error: %v", err)
-				}
-				defer conn.Close()
-
-				// CONNECT fixed header with malformed Remaining Length (5 bytes, MQTT allows max 4)
-				// 0x10 + 0xFF 0xFF 0xFF 0xFF 0x7F
-				_, _ = conn.Write([]byte{0x10, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F})
+	// Cross-transport matrix: 在 A 上发布、在 B 上订阅，覆盖所有 endpoint 的有序对
+	t.Run("Cross_Transport", func(t *testing.T) {
+		parallel(t)
+		testCrossTransport(t, eps)
+	})
 
-				b, err := mustReadSome(conn, 2*time.Second)
-				// We accept either close (err) or an error response (rare). Key is: no hang.
-				if err == nil && len(b) > 0 {
-					_ = b
-				}
-			})
+	// Payload size sweep (TCP+WS): 1 字节到最大载荷，按 QoS 记录完整送达的最大值与失败原因
+	t.Run("Payload_Size_Sweep", func(t *testing.T) {
+		parallel(t)
+		testPayloadSweep(t, eps)
+	})
 
-			t.Run("Invalid_CONNECT_GarbageBody", func(t *testing.T) {
-				conn, err := tcpDial(addr, 3*time.Second)
-				if err != nil {
-					t.Fatalf("dial error: %v", err)
-				}
-				defer conn.Close()
+	// Max packet size policy (TCP). 限制值取自后台运行时配置或 MQTT_MAX_PACKET
+	t.Run("MaxPacketSize", func(t *testing.T) {
+		parallel(t)
+		testMaxPacketSize(t, tcpEndpoint(t, eps))
+	})
 
-				// CONNECT with Remaining Length 1 but body garbage. Broker should close.
-				_, _ = conn.Write([]byte{0x10, 0x01, 0x00})
-				_, _ = mustReadSome(conn, 2*time.Second)
-			})
-		})
-	*/
+	// Protocol validation / failure-mode tests: 非法报文由 FuzzMQTT_PacketParser (mqtt_fuzz_test.go)
+	// 在本地 Broker 上覆盖 (需设置 MQTT_FUZZ_NO_BLOCKER=1)
 
 	// Optional: shared subscription distribution (TCP only) - enable with MQTT_STRESS=1
 	t.Run("SharedSubscription_OptIn", func(t *testing.T) {
+		parallel(t)
 		if os.Getenv("MQTT_STRESS") != "1" {
 			t.Skip("set MQTT_STRESS=1 to run shared subscription distribution test")
 		}
-		tcp := eps[0].url
+		tcp := tcpEndpoint(t, eps)
 		topic := topicWithSuffix("cp7/test/shared")
 		filter := "$share/g1/" + topic
 
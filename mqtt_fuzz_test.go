@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/binary"
+	"strconv"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// 报文解析器模糊测试：把生成/变异的 MQTT 帧 (非法剩余长度、截断的固定头、保留标志位、超长字符串等)
+// 发送给 TestMain 拉起的本地 Broker，每个输入之后检查 Broker 进程仍在运行、新连接仍能正常 CONNECT，
+// 且一直在线的正常客户端不受影响。
+//
+//	go test -run '^$' -fuzz FuzzMQTT_PacketParser -fuzztime 5m mqtt_*_test.go
+//
+// 每个 fuzz worker 进程都会通过 TestMain 拉起自己的 Broker，互不影响。导致失败的输入由 go test
+// 保存到 testdata/fuzz/FuzzMQTT_PacketParser/，之后普通的 go test 会把它们作为种子语料回放。
+//
+// 非法报文会触发 IpBlocker 封禁测试 IP，后台只提供封禁/解封而没有白名单，因此需确认本地 Broker
+// 不会封禁 127.0.0.1 并设置 MQTT_FUZZ_NO_BLOCKER=1 才执行。
+
+// fuzzSeeds 返回初始语料：每一项都针对解析器的一类边界情况。
+func fuzzSeeds() [][]byte {
+	long := make([]byte, 0, 8)
+	long = binary.BigEndian.AppendUint16(long, 0xFFFF) // 声明 65535 字节的字符串，实际只有 2 字节
+	long = append(long, 'a', 'b')
+
+	return [][]byte{
+		// 非法剩余长度：5 字节变长编码 (最多 4 字节)
+		{0x10, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F},
+		// 剩余长度 4 字节但最后一个字节仍带延续位
+		{0x30, 0x80, 0x80, 0x80, 0x80},
+		// 剩余长度超过 268435455 (第 5 个字节仍有值)
+		{0x30, 0xFF, 0xFF, 0xFF, 0xFF, 0x01},
+		// 截断的固定头
+		{0x30},
+		{0x82, 0x05, 0x00},
+		// 剩余长度 1 但报文体不完整
+		{0x10, 0x01, 0x00},
+		// 保留标志位：SUBSCRIBE 标志必须为 0x02，PUBREL 同理
+		encodeFrame(0x80, (&subscribePacket{PacketID: 1, Topics: []subscription{{Filter: "a/b", QoS: 1}}}).body()),
+		encodeFrame(0x60, []byte{0x00, 0x01}),
+		// PUBLISH QoS 3
+		encodeFrame(0x36, (&publishPacket{QoS: 1, Topic: "a/b", PacketID: 1, Payload: []byte("x")}).body()),
+		// CONNECT 保留位置位
+		encodePacket(&connectPacket{Reserved: true, KeepAlive: 60, ClientID: "fuzz"}),
+		// 报文类型 0 和 15 是保留的
+		{0x00, 0x00},
+		{0xF0, 0x00},
+		// 超长字符串：主题长度声明超过报文体
+		encodeFrame(0x30, long),
+		// CONNECT 协议名长度超过报文体
+		encodeFrame(0x10, long),
+		// 主题中包含 U+0000
+		encodePacket(&publishPacket{Topic: "a\x00b", Payload: []byte("x")}),
+		// 主题包含非法 UTF-8
+		encodePacket(&publishPacket{Topic: "a\xff\xfeb", Payload: []byte("x")}),
+		// 主题中包含通配符
+		encodePacket(&publishPacket{Topic: "a/+/#", Payload: []byte("x")}),
+		// 没有任何订阅项的 SUBSCRIBE / UNSUBSCRIBE
+		encodeFrame(0x82, []byte{0x00, 0x01}),
+		encodeFrame(0xA2, []byte{0x00, 0x01}),
+		// 重复 CONNECT
+		encodePacket(&connectPacket{KeepAlive: 60, ClientID: "fuzz_dup"}),
+		// 正常的多个报文粘包
+		append(encodePacket(&publishPacket{Topic: "fuzz/ok", Payload: []byte("1")}), encodePacket(&emptyPacket{Type: pktPINGREQ})...),
+	}
+}
+
+// fuzzCanary 是整个模糊测试期间保持在线的正常客户端，每个输入之后做一次 QoS 1 往返。
+type fuzzCanary struct {
+	client mqtt.Client
+	topic  string
+	got    chan string
+	seq    int
+}
+
+func newFuzzCanary(f *testing.F, url string) *fuzzCanary {
+	c := &fuzzCanary{topic: topicWithSuffix("cp7/test/fuzz_canary"), got: make(chan string, 16)}
+	opts := newClientOptions(url, "fuzz_canary_"+randSuffix(), true)
+	c.client = mqtt.NewClient(opts)
+	if tok := c.client.Connect(); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		f.Fatalf("canary connect: %v", tok.Error())
+	}
+	tok := c.client.Subscribe(c.topic, 1, func(client mqtt.Client, msg mqtt.Message) {
+		select {
+		case c.got <- string(msg.Payload()):
+		default:
+		}
+	})
+	if !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		f.Fatalf("canary subscribe: %v", tok.Error())
+	}
+	f.Cleanup(func() { c.client.Disconnect(250) })
+	return c
+}
+
+// roundTrip 发布一条消息并等待自己收到，失败时返回描述。
+func (c *fuzzCanary) roundTrip() string {
+	if !c.client.IsConnectionOpen() {
+		return "canary client lost its connection"
+	}
+	c.seq++
+	want := strconv.Itoa(c.seq)
+	if tok := c.client.Publish(c.topic, 1, false, want); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		return "canary publish failed"
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-c.got:
+			if got == want {
+				return ""
+			}
+		case <-deadline:
+			return "canary message not delivered"
+		}
+	}
+}
+
+func FuzzMQTT_PacketParser(f *testing.F) {
+	if localBroker == nil {
+		f.Skip("fuzzing only runs against the local broker started by TestMain (set MQTT_BROKER_BIN)")
+	}
+	b := localBroker
+	addr := b.cfg.Host
+
+	if getEnv("MQTT_FUZZ_NO_BLOCKER", "") != "1" {
+		f.Skip("malformed packets may get 127.0.0.1 banned by IpBlocker; set MQTT_FUZZ_NO_BLOCKER=1 to run")
+	}
+
+	for _, seed := range fuzzSeeds() {
+		f.Add(false, seed)
+		f.Add(true, seed)
+	}
+	canary := newFuzzCanary(f, "tcp://"+addr)
+
+	f.Fuzz(func(t *testing.T, connectFirst bool, data []byte) {
+		conn, err := tcpDial(addr, 3*time.Second)
+		if err != nil {
+			t.Fatalf("dial broker: %v", err)
+		}
+		if connectFirst {
+			_ = conn.writePacket(&connectPacket{CleanSession: true, KeepAlive: 60, ClientID: "fuzz_" + randSuffix()})
+		}
+		_, _ = conn.Write(data)
+		// 读到 Broker 关闭连接或短暂静默为止，不关心回复内容，只要求不挂起、不崩溃
+		for {
+			if _, _, err := conn.readFrame(200 * time.Millisecond); err != nil {
+				break
+			}
+		}
+		conn.Close()
+
+		if !b.alive() {
+			t.Fatalf("broker exited after input (connectFirst=%v) %x\n%s", connectFirst, data, b.logTail(4096))
+		}
+		// 新连接仍能完成握手
+		c, ack, err := rawConnect(addr, &connectPacket{CleanSession: true, KeepAlive: 60, ClientID: "fuzz_probe_" + randSuffix()}, 5*time.Second)
+		if err != nil {
+			t.Fatalf("broker stopped accepting connections after input %x: %v", data, err)
+		}
+		_ = c.writePacket(&emptyPacket{Type: pktDISCONNECT})
+		c.Close()
+		if ack.ReturnCode != 0 {
+			t.Fatalf("well-formed CONNECT refused with %#x after input %x", ack.ReturnCode, data)
+		}
+		if why := canary.roundTrip(); why != "" {
+			t.Fatalf("%s after input %x", why, data)
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// benchmark/graceful_leave_test.sh 的自动化版本：
+// 在 node-1 上创建 CleanSession=false 的会话并积压 QoS1 消息，SIGTERM 关闭 node-1，
+// 然后断言会话只被推送到一个接管节点，且客户端重连到幸存节点后无需重新订阅即可收到积压消息。
+
+// logText 读取节点的完整日志，节点停止后仍可读取。
+func (n *clusterNode) logText() string {
+	data, _ := os.ReadFile(filepath.Join(n.dir, "broker.log"))
+	return string(data)
+}
+
+func TestMQTT_Cluster_GracefulLeave(t *testing.T) {
+	c := newTestCluster(t, 3)
+	if c.bin == "" {
+		t.Skip("graceful leave needs locally launched nodes (unset MQTT_CLUSTER_URLS)")
+	}
+	clients := getEnvInt(t, "MQTT_GRACEFUL_CLIENTS", 10)
+	pending := getEnvInt(t, "MQTT_GRACEFUL_MESSAGES", 5)
+
+	leaving := c.nodes[0]
+	topic := topicWithSuffix("graceful/test/topic")
+	prefix := "graceful_test_" + randSuffix() + "_"
+
+	// 1. 在 node-1 创建持久会话；node-1 随后会被关闭，结束时在始终存活的 node-2 上清理
+	for i := 0; i < clients; i++ {
+		cleanupSession(t, c.nodes[1].url, prefix+strconv.Itoa(i))
+		s := createClient(leaving.url, prefix+strconv.Itoa(i), false)
+		mustConnect(t, s, 5*time.Second)
+		mustWaitToken(t, s.Subscribe(topic, 1, nil), 5*time.Second, "subscribe")
+		s.Disconnect(250)
+	}
+
+	// 2. 从另一个节点发布，使每个离线会话都积压 QoS1 消息
+	pub := createClient(c.nodes[1].url, "graceful_pub_"+randSuffix(), true)
+	mustConnect(t, pub, 5*time.Second)
+	for i := 0; i < pending; i++ {
+		mustWaitToken(t, pub.Publish(topic, 1, false, "pending_"+strconv.Itoa(i)), 10*time.Second, "publish")
+	}
+	pub.Disconnect(250)
+	time.Sleep(time.Second)
+
+	// 3. 优雅关闭 node-1 (stopNode 会清空 leaving.broker，先保留进程句柄用于读取日志)
+	proc := leaving.broker
+	c.stopNode(t, 0)
+	if !strings.Contains(leaving.logText(), "selected takeover node") {
+		t.Errorf("%s did not log takeover node selection:\n%s", leaving.name, proc.logTail(2048))
+	}
+
+	// 4. 只有一个幸存节点应收到全部会话
+	t.Run("Single_Takeover_Node", func(t *testing.T) {
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			counts := make(map[string]int)
+			for _, i := range c.liveNodes() {
+				node := c.nodes[i]
+				log := node.logText()
+				for j := 0; j < clients; j++ {
+					if strings.Contains(log, "received session takeover for client "+prefix+strconv.Itoa(j)+" ") {
+						counts[node.name]++
+					}
+				}
+			}
+			if len(counts) == 1 {
+				for name, n := range counts {
+					if n == clients {
+						t.Logf("takeover node: %s (%d sessions)", name, n)
+						return
+					}
+				}
+			}
+			if len(counts) > 1 {
+				t.Fatalf("sessions pushed to more than one node: %v", counts)
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected all %d sessions on exactly one takeover node, got %v", clients, counts)
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+	})
+
+	// 5. 重连到幸存节点 (轮流分布，非接管节点需经 SessionIndex 拉取)，不重新订阅
+	t.Run("Reconnect_Without_Resubscribe", func(t *testing.T) {
+		live := c.liveNodes()
+		for i := 0; i < clients; i++ {
+			id := prefix + strconv.Itoa(i)
+			node := c.nodes[live[i%len(live)]]
+			t.Run(id, func(t *testing.T) {
+				var mu sync.Mutex
+				var got []string
+				opts := newClientOptions(node.url, id, false)
+				opts.SetDefaultPublishHandler(func(client mqtt.Client, msg mqtt.Message) {
+					mu.Lock()
+					got = append(got, string(msg.Payload()))
+					mu.Unlock()
+				})
+				s := mqtt.NewClient(opts)
+				tok := s.Connect()
+				mustWaitToken(t, tok, 5*time.Second, "connect")
+				defer s.Disconnect(250)
+				if ct, ok := tok.(*mqtt.ConnectToken); ok && !ct.SessionPresent() {
+					t.Errorf("session present = false after takeover on %s", node.name)
+				}
+
+				fresh := "fresh_" + randSuffix()
+				p := createClient(node.url, "graceful_pub2_"+randSuffix(), true)
+				mustConnect(t, p, 5*time.Second)
+				defer p.Disconnect(250)
+				mustWaitToken(t, p.Publish(topic, 1, false, fresh), 10*time.Second, "publish")
+
+				deadline := time.Now().Add(10 * time.Second)
+				for {
+					mu.Lock()
+					n := len(got)
+					done := n > 0 && got[n-1] == fresh
+					mu.Unlock()
+					if done && n >= pending+1 {
+						break
+					}
+					if time.Now().After(deadline) {
+						break
+					}
+					time.Sleep(100 * time.Millisecond)
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				want := make([]string, 0, pending+1)
+				for j := 0; j < pending; j++ {
+					want = append(want, "pending_"+strconv.Itoa(j))
+				}
+				want = append(want, fresh)
+				if strings.Join(got, ",") != strings.Join(want, ",") {
+					t.Fatalf("on %s got %v, want %v", node.name, got, want)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Keep Alive 超时处理 (协议 Section 3.1.2.10)：客户端在 Keep Alive 的 1.5 倍时间内没有发送任何报文时，
+// Broker 必须断开连接，并按异常断开处理发布遗嘱。
+// MQTT_KEEPALIVE_SECONDS 指定测试使用的 Keep Alive (默认 2 秒)，
+// MQTT_KEEPALIVE_TOLERANCE_MS 为断开时间窗口的容差 (默认 1000 毫秒)。
+
+func testKeepAlive(t *testing.T, tcp string) {
+	addr := tcpAddrFromMQTTURL(tcp)
+	keepAlive := time.Duration(getEnvInt(t, "MQTT_KEEPALIVE_SECONDS", 2)) * time.Second
+	tolerance := time.Duration(getEnvInt(t, "MQTT_KEEPALIVE_TOLERANCE_MS", 1000)) * time.Millisecond
+	window := keepAlive*3/2 + tolerance
+
+	// subscribeWill 订阅遗嘱主题，返回收到遗嘱的时间 (未收到为 0)
+	subscribeWill := func(t *testing.T, topic, payload string) *atomic.Int64 {
+		var at atomic.Int64
+		sub := createClient(tcp, "ka_sub_"+randSuffix(), true)
+		mustConnect(t, sub, 5*time.Second)
+		t.Cleanup(func() { sub.Disconnect(250) })
+		mustWaitToken(t, sub.Subscribe(topic, 1, func(client mqtt.Client, msg mqtt.Message) {
+			if string(msg.Payload()) == payload {
+				at.CompareAndSwap(0, time.Now().UnixNano())
+			}
+		}), 5*time.Second, "sub")
+		return &at
+	}
+
+	connectWithWill := func(t *testing.T, topic, payload string) *packetConn {
+		conn, ack, err := rawConnect(addr, &connectPacket{
+			CleanSession: true,
+			KeepAlive:    uint16(keepAlive / time.Second),
+			ClientID:     "ka_client_" + randSuffix(),
+			WillFlag:     true,
+			WillTopic:    topic,
+			WillMessage:  []byte(payload),
+		}, 5*time.Second)
+		if err != nil {
+			t.Fatalf("CONNECT failed or timeout: %v", err)
+		}
+		if ack.ReturnCode != 0x00 {
+			conn.Close()
+			t.Fatalf("CONNACK refused: return code %#x", ack.ReturnCode)
+		}
+		return conn
+	}
+
+	t.Run("Idle_Timeout_Disconnect_And_Will", func(t *testing.T) {
+		// 连接后保持静默，Broker 应在 [KeepAlive, 1.5×KeepAlive + 容差] 内断开并发布遗嘱
+		willTopic := "will/keepalive/" + randSuffix()
+		willPayload := "keepalive_expired"
+		willAt := subscribeWill(t, willTopic, willPayload)
+
+		conn := connectWithWill(t, willTopic, willPayload)
+		defer conn.Close()
+		start := time.Now()
+
+		p, err := conn.readPacket(window + 5*time.Second)
+		elapsed := time.Since(start)
+		var ne net.Error
+		switch {
+		case err == nil:
+			t.Fatalf("unexpected %s while idle", packetName(p.packetType()))
+		case errors.As(err, &ne) && ne.Timeout():
+			t.Fatalf("broker did not close idle connection within %v (keep alive %v)", elapsed, keepAlive)
+		}
+		t.Logf("idle connection closed after %v (keep alive %v, window %v)", elapsed, keepAlive, window)
+		if elapsed < keepAlive {
+			t.Errorf("connection closed after %v, before keep alive %v elapsed", elapsed, keepAlive)
+		}
+		if elapsed > window {
+			t.Errorf("connection closed after %v, want within 1.5×keep alive + tolerance = %v", elapsed, window)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for willAt.Load() == 0 && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		if willAt.Load() == 0 {
+			t.Fatal("LWT message not received after keep alive timeout")
+		}
+	})
+
+	t.Run("PingReq_Keeps_Session_Alive", func(t *testing.T) {
+		// 每半个 Keep Alive 发送 PINGREQ，持续 3 倍 Keep Alive，连接应保持且不触发遗嘱
+		willTopic := "will/keepalive_ping/" + randSuffix()
+		willPayload := "should_not_see_this"
+		willAt := subscribeWill(t, willTopic, willPayload)
+
+		conn := connectWithWill(t, willTopic, willPayload)
+		defer conn.Close()
+
+		for end := time.Now().Add(3 * keepAlive); time.Now().Before(end); {
+			time.Sleep(keepAlive / 2)
+			if err := conn.writePacket(&emptyPacket{Type: pktPINGREQ}); err != nil {
+				t.Fatalf("send PINGREQ: %v", err)
+			}
+			if p, err := expectPacket[*emptyPacket](conn, 5*time.Second); err != nil || p.Type != pktPINGRESP {
+				t.Fatalf("waiting for PINGRESP: %v", err)
+			}
+		}
+
+		_ = conn.writePacket(&emptyPacket{Type: pktDISCONNECT})
+		time.Sleep(time.Second)
+		if willAt.Load() != 0 {
+			t.Fatal("LWT message published although PINGREQ kept the connection alive")
+		}
+	})
+}
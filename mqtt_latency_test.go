@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// 端到端延迟测量：每条消息载荷携带 8 字节序号 + 8 字节发送时间戳 (UnixNano)，
+// 订阅端按 endpoint × QoS 收集样本并输出 p50/p99/p999。
+// 设置 MQTT_LATENCY=1 或任一阈值变量时启用；阈值单位为毫秒，0 表示不检查：
+//   MQTT_LATENCY_P50_MS / MQTT_LATENCY_P99_MS / MQTT_LATENCY_P999_MS
+// 样本数和发布间隔由 MQTT_LATENCY_SAMPLES (默认 1000) 和 MQTT_LATENCY_INTERVAL_MS (默认 1) 控制。
+// 测量在独立的顶层测试 TestMQTT_Latency 中逐个 endpoint 串行进行，不与并行的功能子测试争抢 Broker。
+
+const latencyHeaderLen = 16
+
+func latencyEnabled() bool {
+	for _, k := range []string{"MQTT_LATENCY", "MQTT_LATENCY_P50_MS", "MQTT_LATENCY_P99_MS", "MQTT_LATENCY_P999_MS"} {
+		if v := getEnv(k, ""); v != "" && v != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+func stampLatencyPayload(seq uint64, size int) []byte {
+	p := make([]byte, max(size, latencyHeaderLen))
+	binary.BigEndian.PutUint64(p, seq)
+	binary.BigEndian.PutUint64(p[8:], uint64(time.Now().UnixNano()))
+	return p
+}
+
+func parseLatencyPayload(p []byte) (seq uint64, sent time.Time, ok bool) {
+	if len(p) < latencyHeaderLen {
+		return 0, time.Time{}, false
+	}
+	return binary.BigEndian.Uint64(p), time.Unix(0, int64(binary.BigEndian.Uint64(p[8:]))), true
+}
+
+// latencySamples 保存一轮测量的全部样本。每轮样本数只有数千，直接排序求分位数；
+// 百万级样本的直方图只在 benchmark/axmq-bench 中实现。
+type latencySamples struct {
+	mu sync.Mutex
+	d  []time.Duration
+}
+
+func (s *latencySamples) record(d time.Duration) {
+	s.mu.Lock()
+	s.d = append(s.d, max(d, 0))
+	s.mu.Unlock()
+}
+
+func (s *latencySamples) count() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return uint64(len(s.d))
+}
+
+// quantile 返回 q (0..1) 分位的样本 (最近秩法)，没有样本时返回 0。
+func (s *latencySamples) quantile(q float64) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.d) == 0 {
+		return 0
+	}
+	slices.Sort(s.d)
+	rank := min(max(int(math.Ceil(q*float64(len(s.d)))), 1), len(s.d))
+	return s.d[rank-1]
+}
+
+// latencyThreshold 读取毫秒阈值，未设置时返回 0。
+func latencyThreshold(t *testing.T, key string) time.Duration {
+	return time.Duration(getEnvInt(t, key, 0)) * time.Millisecond
+}
+
+// testLatency 在单个 endpoint 上分别测量 QoS 0/1/2 的端到端延迟。
+func testLatency(t *testing.T, ep brokerEndpoint) {
+	if !latencyEnabled() {
+		t.Skip("set MQTT_LATENCY=1 (or MQTT_LATENCY_P99_MS etc.) to measure end-to-end latency")
+	}
+	samples := getEnvInt(t, "MQTT_LATENCY_SAMPLES", 1000)
+	interval := time.Duration(getEnvInt(t, "MQTT_LATENCY_INTERVAL_MS", 1)) * time.Millisecond
+	limits := []struct {
+		name string
+		q    float64
+		max  time.Duration
+	}{
+		{"p50", 0.50, latencyThreshold(t, "MQTT_LATENCY_P50_MS")},
+		{"p99", 0.99, latencyThreshold(t, "MQTT_LATENCY_P99_MS")},
+		{"p999", 0.999, latencyThreshold(t, "MQTT_LATENCY_P999_MS")},
+	}
+
+	for qos := byte(0); qos <= 2; qos++ {
+		qos := qos
+		t.Run("QoS"+strconv.Itoa(int(qos)), func(t *testing.T) {
+			topic := topicWithSuffix("cp7/test/latency")
+			h := &latencySamples{}
+			var mu sync.Mutex
+			seen := make(map[uint64]bool, samples)
+			dups := 0
+
+			sub := createClient(ep.url, ep.name+"_latsub_"+randSuffix(), true)
+			mustConnect(t, sub, 5*time.Second)
+			defer sub.Disconnect(250)
+			mustWaitToken(t, sub.Subscribe(topic, qos, func(client mqtt.Client, msg mqtt.Message) {
+				seq, sent, ok := parseLatencyPayload(msg.Payload())
+				if !ok {
+					return
+				}
+				mu.Lock()
+				if seen[seq] {
+					dups++
+					mu.Unlock()
+					return
+				}
+				seen[seq] = true
+				mu.Unlock()
+				h.record(time.Since(sent))
+			}), 5*time.Second, "subscribe")
+
+			pub := createClient(ep.url, ep.name+"_latpub_"+randSuffix(), true)
+			mustConnect(t, pub, 5*time.Second)
+			defer pub.Disconnect(250)
+
+			for i := 0; i < samples; i++ {
+				tok := pub.Publish(topic, qos, false, stampLatencyPayload(uint64(i), 64))
+				if qos > 0 {
+					mustWaitToken(t, tok, 10*time.Second, "publish")
+				}
+				if interval > 0 {
+					time.Sleep(interval)
+				}
+			}
+
+			deadline := time.Now().Add(10 * time.Second)
+			for h.count() < uint64(samples) && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			got := h.count()
+			t.Logf("%s QoS%d: n=%d/%d p50=%v p99=%v p999=%v max=%v", ep.name, qos, got, samples,
+				h.quantile(0.50), h.quantile(0.99), h.quantile(0.999), h.quantile(1))
+			if qos > 0 && got < uint64(samples) {
+				t.Errorf("lost %d/%d QoS%d messages", uint64(samples)-got, samples, qos)
+			}
+			if qos == 2 && dups > 0 {
+				t.Errorf("received %d duplicate QoS2 messages", dups)
+			}
+			for _, l := range limits {
+				if v := h.quantile(l.q); l.max > 0 && v > l.max {
+					t.Errorf("%s latency %v exceeds MQTT_LATENCY_%s_MS=%v", l.name, v, strings.ToUpper(l.name), l.max)
+				}
+			}
+		})
+	}
+}
+
+func TestMQTT_Latency(t *testing.T) {
+	for _, ep := range mustEndpoints(t) {
+		ep := ep
+		t.Run(ep.name, func(t *testing.T) {
+			testLatency(t, ep)
+		})
+	}
+}
+
+func TestMQTT_LatencyQuantile(t *testing.T) {
+	// 分位数自检，不依赖 Broker
+	s := &latencySamples{}
+	for i := 1000; i >= 1; i-- {
+		s.record(time.Duration(i) * time.Millisecond)
+	}
+	for _, c := range []struct {
+		q    float64
+		want time.Duration
+	}{{0, time.Millisecond}, {0.5, 500 * time.Millisecond}, {0.99, 990 * time.Millisecond}, {0.999, 999 * time.Millisecond}, {1, time.Second}} {
+		if got := s.quantile(c.q); got != c.want {
+			t.Errorf("quantile(%v) = %v, want %v", c.q, got, c.want)
+		}
+	}
+}
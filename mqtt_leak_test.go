@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// 泄漏检查：子测试并行执行时，遗留的保留消息或持久会话会影响其他用例 (以及下一次运行)。
+// TestMain 在全部测试结束后 (Broker 关闭前) 确认本次运行创建的保留消息 (主题带 testRunID) 和
+// CleanSession=false 会话 (persistentSessions) 均已清理，发现遗留时输出并顺带清除，退出码置为失败。
+
+// cleanupSession 在测试结束时以 CleanSession=true 连接一次，删除 id 的持久会话。
+func cleanupSession(t *testing.T, url, id string) {
+	t.Cleanup(func() { clearSession(url, id) })
+}
+
+func clearSession(url, id string) {
+	c := createClient(url, id, true)
+	if tok := c.Connect(); tok.WaitTimeout(5*time.Second) && tok.Error() == nil {
+		c.Disconnect(250)
+	}
+}
+
+// sessionPresent 以 CleanSession=false 连接并返回 CONNACK 中的 session present 标志。
+func sessionPresent(url, id string) (bool, error) {
+	c := mqtt.NewClient(newClientOptions(url, id, false))
+	tok := c.Connect()
+	if !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		return false, tok.Error()
+	}
+	c.Disconnect(250)
+	ct, _ := tok.(*mqtt.ConnectToken)
+	return ct != nil && ct.SessionPresent(), nil
+}
+
+// leakedRetained 返回带有本次 testRunID 的保留消息主题。testRunID 在主题中的层级取决于各用例的前缀，
+// 无法写进订阅过滤器，因此订阅 # 收取全部保留消息后按 testRunID 筛选。
+func leakedRetained(url string) ([]string, error) {
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	c := createClient(url, "leak_check_"+randSuffix(), true)
+	if tok := c.Connect(); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		return nil, tok.Error()
+	}
+	defer c.Disconnect(250)
+	tok := c.Subscribe("#", 0, func(client mqtt.Client, msg mqtt.Message) {
+		if msg.Retained() && len(msg.Payload()) > 0 && strings.Contains(msg.Topic(), testRunID) {
+			mu.Lock()
+			seen[msg.Topic()] = true
+			mu.Unlock()
+		}
+	})
+	if !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		return nil, tok.Error()
+	}
+	time.Sleep(time.Duration(getEnvIntOr("MQTT_LEAK_WAIT_MS", 1000)) * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	topics := make([]string, 0, len(seen))
+	for topic := range seen {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// runLeakChecked 运行全部测试后执行泄漏检查，发现遗留时把退出码置为失败。
+func runLeakChecked(m *testing.M) int {
+	code := runReported(m)
+	if checkLeaks(endpointsFromEnv()) > 0 && code == 0 {
+		code = 1
+	}
+	return code
+}
+
+// checkLeaks 检查并清除本次运行遗留的保留消息和持久会话，返回发现的遗留数量。
+func checkLeaks(eps []brokerEndpoint) int {
+	leaks := 0
+	// 同一 Broker 实例只需检查一次保留消息 (外部 Broker 的 endpoint 的 broker 均为空)
+	checked := map[string]bool{}
+	for _, ep := range eps {
+		if checked[ep.broker] {
+			continue
+		}
+		checked[ep.broker] = true
+		topics, err := leakedRetained(ep.url)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "retained leak check on %s skipped: %v\n", ep.name, err)
+			continue
+		}
+		for _, topic := range topics {
+			leaks++
+			fmt.Fprintf(os.Stderr, "LEAK: retained message left on %s by this run: %s\n", ep.name, topic)
+			c := createClient(ep.url, "leak_clear_"+randSuffix(), true)
+			if tok := c.Connect(); tok.WaitTimeout(5*time.Second) && tok.Error() == nil {
+				c.Publish(topic, 1, true, []byte{}).WaitTimeout(5 * time.Second)
+				c.Disconnect(250)
+			}
+		}
+	}
+
+	var ids []string
+	persistentSessions.Range(func(k, v any) bool {
+		ids = append(ids, k.(string))
+		return true
+	})
+	sort.Strings(ids)
+	for _, id := range ids {
+		v, _ := persistentSessions.Load(id)
+		url := v.(string)
+		present, err := sessionPresent(url, id)
+		if err != nil {
+			// 集群等用例自带的 Broker 在此时已关闭，其会话随数据目录一并删除
+			fmt.Fprintf(os.Stderr, "session leak check for %s skipped: %v\n", id, err)
+			continue
+		}
+		if present {
+			leaks++
+			fmt.Fprintf(os.Stderr, "LEAK: persistent session left on %s by this run: %s\n", url, id)
+		}
+		clearSession(url, id)
+	}
+	return leaks
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// 最大报文大小策略：限制值在运行时可通过后台 Config 页面修改，但后台没有公开的 API 文档，
+// 因此由 MQTT_MAX_PACKET 告知测试被测 Broker 当前生效的值，未设置时跳过。
+// 限制按整包长度 (固定报头 + 剩余长度) 计算，恰好在限制处与超出一个字节的用例都以整包长度构造。
+//
+// 超限报文可能触发 IpBlocker 封禁测试 IP。需求中"测试期间把测试 IP 加入白名单"的部分未实现：
+// 后台只提供封禁/解封且没有可调用的接口，所以超限用例需要显式设置 MQTT_TEST_OVERLIMIT=1
+// (确认测试 IP 不会被封禁，或事后在 Blocker 页面手动解封) 才执行。
+
+// publishOfSize 返回整包编码长度恰好为 size 的 QoS 1 PUBLISH。size 小于空载荷报文的长度，
+// 或恰好落在剩余长度变长编码的跳变处而无法凑齐时返回错误。
+func publishOfSize(topic string, id uint16, size int) (*publishPacket, error) {
+	p := &publishPacket{QoS: 1, Topic: topic, PacketID: id}
+	overhead := len(encodePacket(p))
+	if size < overhead {
+		return nil, fmt.Errorf("%d bytes is smaller than an empty PUBLISH to %q (%d bytes)", size, topic, overhead)
+	}
+	p.Payload = make([]byte, size-overhead)
+	// 剩余长度的变长编码随载荷增长可能多占字节，逐字节收缩直到整包长度吻合
+	for n := len(encodePacket(p)); n > size; n = len(encodePacket(p)) {
+		p.Payload = p.Payload[:len(p.Payload)-(n-size)]
+	}
+	if n := len(encodePacket(p)); n != size {
+		return nil, fmt.Errorf("no PUBLISH to %q encodes to exactly %d bytes (closest %d)", topic, size, n)
+	}
+	for i := range p.Payload {
+		p.Payload[i] = byte(i)
+	}
+	return p, nil
+}
+
+func testMaxPacketSize(t *testing.T, tcp string) {
+	addr := tcpAddrFromMQTTURL(tcp)
+	maxPacket := getEnvInt(t, "MQTT_MAX_PACKET", 0)
+	if maxPacket <= 0 {
+		t.Skip("max packet size unknown: set MQTT_MAX_PACKET to the broker's effective limit")
+	}
+
+	connect := func(t *testing.T) *packetConn {
+		conn, ack, err := rawConnect(addr, &connectPacket{CleanSession: true, KeepAlive: 60, ClientID: "maxpkt_" + randSuffix()}, 5*time.Second)
+		if err != nil {
+			t.Fatalf("CONNECT failed or timeout: %v", err)
+		}
+		if ack.ReturnCode != 0x00 {
+			conn.Close()
+			t.Fatalf("CONNACK refused: return code %#x", ack.ReturnCode)
+		}
+		return conn
+	}
+
+	t.Run("Accept_At_Limit", func(t *testing.T) {
+		conn := connect(t)
+		defer conn.Close()
+		p, err := publishOfSize(topicWithSuffix("cp7/test/maxpkt"), 1, maxPacket)
+		if err != nil {
+			t.Skip(err)
+		}
+		if err := conn.writePacket(p); err != nil {
+			t.Fatalf("write %d-byte PUBLISH: %v", maxPacket, err)
+		}
+		if err := expectAck(conn, pktPUBACK, 1, 30*time.Second); err != nil {
+			t.Fatalf("PUBLISH of exactly %d bytes not acknowledged: %v", maxPacket, err)
+		}
+	})
+
+	t.Run("Reject_One_Byte_Over", func(t *testing.T) {
+		if getEnv("MQTT_TEST_OVERLIMIT", "") != "1" {
+			t.Skip("over-limit PUBLISH may get the test IP banned by IpBlocker; set MQTT_TEST_OVERLIMIT=1 to run")
+		}
+		conn := connect(t)
+		defer conn.Close()
+
+		p, err := publishOfSize(topicWithSuffix("cp7/test/overlimit"), 1, maxPacket+1)
+		if err != nil {
+			t.Skip(err)
+		}
+		_ = conn.writePacket(p) // Broker 可能在写完前就关闭连接
+
+		// 拒绝的表现：不回 PUBACK，并断开连接
+		for {
+			pkt, err := conn.readPacket(10 * time.Second)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					t.Fatal("connection still open after over-limit PUBLISH")
+				}
+				return
+			}
+			if ack, ok := pkt.(*ackPacket); ok && ack.Type == pktPUBACK && ack.PacketID == 1 {
+				t.Fatalf("over-limit PUBLISH of %d bytes was acknowledged", maxPacket+1)
+			}
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 消息过期 (MQTT 5.0 Message Expiry Interval，协议 Section 3.3.2.3.3)：
+//   - 离线队列 (QoS 1/2) 和保留消息在过期后必须被丢弃，不再投递给重连的会话或新订阅者；
+//   - 未过期的消息投递时，Message Expiry Interval 必须减去在 Broker 中停留的时间；
+//   - 3.1.1 发布者的消息使用 Broker 的全局默认过期时间。conf.yml 和后台尚未定义该配置项，
+//     因此由 MQTT_DEFAULT_MESSAGE_EXPIRY (秒) 告知测试被测 Broker 上配置的值，未设置或超过 30 秒时跳过。
+// Broker 不接受 v5 连接时整组跳过；需求阻塞在 Broker 侧，见 mqtt_pending_test.go。
+
+// expiryWait 在过期时间之外额外等待的时长，覆盖 Broker 按秒计时的取整误差。
+const expiryWait = 1500 * time.Millisecond
+
+func testMessageExpiry(t *testing.T, tcp string) {
+	addr := tcpAddrFromMQTTURL(tcp)
+
+	// offlineSubscriber 建立一个会话过期时间为 300 秒的 v5 会话并订阅 topic，然后断开；
+	// 返回的函数以 CleanStart=0 恢复该会话。
+	offlineSubscriber := func(t *testing.T, topic string, qos byte) func() *packetConn {
+		t.Helper()
+		id := "expiry_sub_" + randSuffix()
+		t.Cleanup(func() { rawSessionCleanup(addr, id) })
+		connect := func() *packetConn {
+			conn, _ := mustV5RawConnect(t, addr, &connectPacket{ClientID: id, Properties: v5Properties{propSessionExpiry: 300}})
+			return conn
+		}
+		conn := connect()
+		if rc := v5Subscribe(t, conn, 1, topic, qos); rc != qos {
+			t.Fatalf("SUBACK reason %#x, want granted QoS %d", rc, qos)
+		}
+		_ = conn.writePacket(&v5DisconnectPacket{})
+		conn.Close()
+		return connect
+	}
+
+	publisher := func(t *testing.T) *packetConn {
+		t.Helper()
+		conn, _ := mustV5RawConnect(t, addr, &connectPacket{CleanSession: true, ClientID: "expiry_pub_" + randSuffix()})
+		return conn
+	}
+
+	t.Run("Queued_Expired_Dropped", func(t *testing.T) {
+		parallel(t)
+		topic := topicWithSuffix("cp7/test/expiry_queue")
+		resume := offlineSubscriber(t, topic, 2)
+
+		pub := publisher(t)
+		v5Publish(t, pub, &v5PublishPacket{QoS: 1, PacketID: 1, Topic: topic,
+			Properties: v5Properties{propMessageExpiry: 1}, Payload: []byte("stale_qos1")})
+		v5Publish(t, pub, &v5PublishPacket{QoS: 2, PacketID: 2, Topic: topic,
+			Properties: v5Properties{propMessageExpiry: 1}, Payload: []byte("stale_qos2")})
+		v5Publish(t, pub, &v5PublishPacket{QoS: 1, PacketID: 3, Topic: topic, Payload: []byte("fresh")})
+		time.Sleep(time.Second + expiryWait)
+
+		sub := resume()
+		if p := v5ExpectPublish(t, sub, 5*time.Second); string(p.Payload) != "fresh" {
+			t.Fatalf("resumed session got %q, want only the unexpired message", p.Payload)
+		}
+		v5ExpectNoPublish(t, sub, time.Second)
+	})
+
+	t.Run("Queued_Remaining_Interval", func(t *testing.T) {
+		parallel(t)
+		topic := topicWithSuffix("cp7/test/expiry_remaining")
+		resume := offlineSubscriber(t, topic, 1)
+
+		const interval = 60
+		v5Publish(t, publisher(t), &v5PublishPacket{QoS: 1, PacketID: 1, Topic: topic,
+			Properties: v5Properties{propMessageExpiry: interval}, Payload: []byte("queued")})
+		const held = 2 * time.Second
+		time.Sleep(held)
+
+		p := v5ExpectPublish(t, resume(), 5*time.Second)
+		expectRemainingInterval(t, p, interval, held)
+	})
+
+	t.Run("Online_Forwarded_With_Interval", func(t *testing.T) {
+		parallel(t)
+		// 在线订阅者立即收到消息，过期属性随消息转发且不大于原值
+		topic := topicWithSuffix("cp7/test/expiry_online")
+		sub, _ := mustV5RawConnect(t, addr, &connectPacket{CleanSession: true, ClientID: "expiry_online_" + randSuffix()})
+		v5Subscribe(t, sub, 1, topic, 1)
+		v5Publish(t, publisher(t), &v5PublishPacket{QoS: 1, PacketID: 1, Topic: topic,
+			Properties: v5Properties{propMessageExpiry: 30}, Payload: []byte("live")})
+		p := v5ExpectPublish(t, sub, 5*time.Second)
+		expectRemainingInterval(t, p, 30, 0)
+	})
+
+	t.Run("Retained_Expired_Dropped", func(t *testing.T) {
+		parallel(t)
+		topic := topicWithSuffix("cp7/test/expiry_retain")
+		t.Cleanup(func() { clearRetained(addr, topic) })
+		v5Publish(t, publisher(t), &v5PublishPacket{QoS: 1, PacketID: 1, Retain: true, Topic: topic,
+			Properties: v5Properties{propMessageExpiry: 1}, Payload: []byte("stale_retained")})
+		time.Sleep(time.Second + expiryWait)
+
+		sub, _ := mustV5RawConnect(t, addr, &connectPacket{CleanSession: true, ClientID: "expiry_rsub_" + randSuffix()})
+		v5Subscribe(t, sub, 1, topic, 1)
+		v5ExpectNoPublish(t, sub, time.Second)
+
+		// 3.1.1 订阅者同样不应收到
+		old := confClient(t, addr)
+		confSubscribe(t, old, 1, topic, 1)
+		expectNoPublish(t, old, time.Second)
+	})
+
+	t.Run("Retained_Remaining_Interval", func(t *testing.T) {
+		parallel(t)
+		topic := topicWithSuffix("cp7/test/expiry_retain_remaining")
+		t.Cleanup(func() { clearRetained(addr, topic) })
+		const interval = 60
+		v5Publish(t, publisher(t), &v5PublishPacket{QoS: 1, PacketID: 1, Retain: true, Topic: topic,
+			Properties: v5Properties{propMessageExpiry: interval}, Payload: []byte("retained")})
+		const held = 2 * time.Second
+		time.Sleep(held)
+
+		sub, _ := mustV5RawConnect(t, addr, &connectPacket{CleanSession: true, ClientID: "expiry_rsub_" + randSuffix()})
+		v5Subscribe(t, sub, 1, topic, 1)
+		p := v5ExpectPublish(t, sub, 5*time.Second)
+		if !p.Retain {
+			t.Fatal("retained message delivered without the RETAIN flag")
+		}
+		expectRemainingInterval(t, p, interval, held)
+	})
+
+	t.Run("Default_Expiry_V311", func(t *testing.T) {
+		parallel(t)
+		def := getEnvInt(t, "MQTT_DEFAULT_MESSAGE_EXPIRY", 0)
+		if def <= 0 || def > 30 {
+			t.Skipf("default message expiry is %d s: set MQTT_DEFAULT_MESSAGE_EXPIRY to the broker's 1-30 s default to run", def)
+		}
+		wait := time.Duration(def)*time.Second + expiryWait
+
+		queued := topicWithSuffix("cp7/test/expiry_default")
+		retained := topicWithSuffix("cp7/test/expiry_default_retain")
+		t.Cleanup(func() { clearRetained(addr, retained) })
+
+		id := "expiry_v311_" + randSuffix()
+		t.Cleanup(func() { rawSessionCleanup(addr, id) })
+		sub := rawSessionConnect(t, addr, id, false)
+		confSubscribe(t, sub, 1, queued, 1)
+		_ = sub.writePacket(&emptyPacket{Type: pktDISCONNECT})
+		sub.Close()
+
+		pub := confClient(t, addr)
+		confPublish(t, pub, &publishPacket{QoS: 1, PacketID: 1, Topic: queued, Payload: []byte("stale_queued")})
+		confPublish(t, pub, &publishPacket{QoS: 1, PacketID: 2, Retain: true, Topic: retained, Payload: []byte("stale_retained")})
+		time.Sleep(wait)
+
+		resumed := rawSessionConnect(t, addr, id, true)
+		defer resumed.Close()
+		expectNoPublish(t, resumed, time.Second)
+
+		fresh := confClient(t, addr)
+		confSubscribe(t, fresh, 1, retained, 1)
+		expectNoPublish(t, fresh, time.Second)
+	})
+}
+
+// expectRemainingInterval 断言转发的 Message Expiry Interval 已扣除消息在 Broker 中停留的 held 时长。
+func expectRemainingInterval(t *testing.T, p *v5PublishPacket, interval uint32, held time.Duration) {
+	t.Helper()
+	got, ok := p.Properties[propMessageExpiry]
+	if !ok {
+		t.Fatal("Message Expiry Interval not forwarded with the message")
+	}
+	// 按秒取整，允许 1 秒误差
+	if limit := interval - uint32(held/time.Second) + 1; got > limit || got == 0 {
+		t.Fatalf("Message Expiry Interval = %d after %v in the broker, want 1..%d", got, held, limit)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// MQTT 3.1 (协议名 MQIsdp，协议级别 3) 兼容性：旧网关仍以 3.1 连接。与 3.1.1 的差异：
+//   - ClientId 长度必须为 1-23 个字符，否则返回 CONNACK 0x02 (Identifier rejected)，不允许空 ClientId；
+//   - CONNACK 的第一个字节是保留字节 (3.1 没有 Session Present 标志)，恢复会话时也必须为 0。
+// 会话、主题树和遗嘱与 3.1.1 客户端共用。
+// Broker 拒绝协议级别 3 (CONNACK 0x01 或直接断开) 时整组跳过；需求阻塞在 Broker 侧，见 mqtt_pending_test.go。
+
+const mqisdpMaxClientID = 23
+
+// mqisdpConnect 以 MQIsdp/3 发送 CONNECT，返回连接和原始 CONNACK 报文体 (2 字节)。
+func mqisdpConnect(t *testing.T, addr string, p *connectPacket) (*packetConn, []byte) {
+	t.Helper()
+	p.ProtocolName, p.ProtocolLevel = "MQIsdp", 3
+	if p.KeepAlive == 0 {
+		p.KeepAlive = 60
+	}
+	applyCredentials(p)
+	conn, err := tcpDial(addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if !p.CleanSession && p.ClientID != "" {
+		persistentSessions.Store(p.ClientID, "tcp://"+addr)
+	}
+	if err := conn.writePacket(p); err != nil {
+		t.Fatalf("write CONNECT: %v", err)
+	}
+	header, body, err := conn.readFrame(5 * time.Second)
+	if err != nil {
+		t.Fatalf("waiting for CONNACK to MQIsdp CONNECT %q: %v", p.ClientID, err)
+	}
+	if header != pktCONNACK<<4 || len(body) != 2 {
+		t.Fatalf("unexpected reply to MQIsdp CONNECT: header %#x, %d byte body", header, len(body))
+	}
+	return conn, body
+}
+
+// mustConnectMQIsdp 要求 3.1 连接被接受，且 CONNACK 保留字节为 0。
+func mustConnectMQIsdp(t *testing.T, addr string, p *connectPacket) *packetConn {
+	t.Helper()
+	conn, ack := mqisdpConnect(t, addr, p)
+	if ack[1] != 0 {
+		t.Fatalf("MQIsdp CONNECT %q refused: return code %#x", p.ClientID, ack[1])
+	}
+	if ack[0] != 0 {
+		t.Fatalf("CONNACK reserved byte = %#x for an MQIsdp client, want 0", ack[0])
+	}
+	return conn
+}
+
+// requireMQIsdp 探测 Broker 是否接受 MQIsdp/3 连接，不接受时跳过。
+func requireMQIsdp(t *testing.T, addr string) {
+	t.Helper()
+	conn, err := tcpDial(addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	p := &connectPacket{ProtocolName: "MQIsdp", ProtocolLevel: 3, CleanSession: true, KeepAlive: 60, ClientID: "mqisdp_probe"}
+	applyCredentials(p)
+	if err := conn.writePacket(p); err != nil {
+		t.Fatalf("write CONNECT: %v", err)
+	}
+	header, body, err := conn.readFrame(5 * time.Second)
+	switch {
+	case err != nil:
+		t.Skipf("broker does not accept MQIsdp (protocol level 3): connection closed: %v", err)
+	case header == pktCONNACK<<4 && len(body) == 2 && body[1] == 0x01:
+		t.Skip("broker does not accept MQIsdp (protocol level 3): CONNACK 0x01")
+	}
+	_ = conn.writePacket(&emptyPacket{Type: pktDISCONNECT})
+}
+
+func testMQIsdp(t *testing.T, tcp string) {
+	addr := tcpAddrFromMQTTURL(tcp)
+	requireMQIsdp(t, addr)
+
+	t.Run("Connect_Accepted", func(t *testing.T) {
+		parallel(t)
+		conn := mustConnectMQIsdp(t, addr, &connectPacket{CleanSession: true, ClientID: "mqisdp_" + randSuffix()})
+		_ = conn.writePacket(&emptyPacket{Type: pktPINGREQ})
+		if _, err := expectPacket[*emptyPacket](conn, 5*time.Second); err != nil {
+			t.Fatalf("waiting for PINGRESP: %v", err)
+		}
+	})
+
+	t.Run("ClientID_23_Chars", func(t *testing.T) {
+		parallel(t)
+		id := ("m23_" + randSuffix() + strings.Repeat("x", mqisdpMaxClientID))[:mqisdpMaxClientID]
+		mustConnectMQIsdp(t, addr, &connectPacket{CleanSession: true, ClientID: id})
+	})
+
+	for _, tc := range []struct{ name, id string }{
+		{"ClientID_24_Chars_Rejected", strings.Repeat("y", mqisdpMaxClientID+1)},
+		{"ClientID_Empty_Rejected", ""},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			parallel(t)
+			conn, ack := mqisdpConnect(t, addr, &connectPacket{CleanSession: true, ClientID: tc.id})
+			if ack[1] != 0x02 {
+				t.Fatalf("CONNACK return code %#x for %d-character ClientId, want 0x02 (identifier rejected)", ack[1], len(tc.id))
+			}
+			expectClosed(t, conn, 5*time.Second)
+		})
+	}
+
+	t.Run("PubSub_With_V311", func(t *testing.T) {
+		parallel(t)
+		// 3.1 与 3.1.1 客户端共享主题树
+		topic := topicWithSuffix("cp7/test/mqisdp")
+		old := mustConnectMQIsdp(t, addr, &connectPacket{CleanSession: true, ClientID: "mqisdp_ps_" + randSuffix()})
+		if qos := confSubscribe(t, old, 1, topic, 1); qos != 1 {
+			t.Fatalf("SUBACK granted %#x, want 1", qos)
+		}
+
+		v311 := confClient(t, addr)
+		confPublish(t, v311, &publishPacket{QoS: 1, PacketID: 1, Topic: topic, Payload: []byte("from_311")})
+		if p := confExpectPublish(t, old); string(p.Payload) != "from_311" {
+			t.Fatalf("MQIsdp subscriber got %q, want from_311", p.Payload)
+		}
+
+		confSubscribe(t, v311, 2, topic, 1)
+		confPublish(t, old, &publishPacket{QoS: 1, PacketID: 2, Topic: topic, Payload: []byte("from_31")})
+		if p := confExpectPublish(t, v311); string(p.Payload) != "from_31" {
+			t.Fatalf("3.1.1 subscriber got %q, want from_31", p.Payload)
+		}
+	})
+
+	t.Run("Session_Resumed_Reserved_Byte", func(t *testing.T) {
+		parallel(t)
+		// 恢复会话时 3.1 的 CONNACK 仍不得置位 Session Present，但离线消息照常投递
+		id := "mqisdp_s_" + randSuffix()
+		topic := topicWithSuffix("cp7/test/mqisdp_session")
+		t.Cleanup(func() { rawSessionCleanup(addr, id) })
+
+		first := mustConnectMQIsdp(t, addr, &connectPacket{ClientID: id})
+		confSubscribe(t, first, 1, topic, 1)
+		_ = first.writePacket(&emptyPacket{Type: pktDISCONNECT})
+		first.Close()
+
+		confPublish(t, confClient(t, addr), &publishPacket{QoS: 1, PacketID: 1, Topic: topic, Payload: []byte("queued")})
+
+		resumed := mustConnectMQIsdp(t, addr, &connectPacket{ClientID: id})
+		if p := confExpectPublish(t, resumed); string(p.Payload) != "queued" {
+			t.Fatalf("resumed MQIsdp session got %q, want queued", p.Payload)
+		}
+	})
+
+	t.Run("LWT", func(t *testing.T) {
+		parallel(t)
+		for _, tc := range []struct {
+			name       string
+			disconnect bool
+		}{{"Abnormal_Close_Publishes_Will", false}, {"Disconnect_Discards_Will", true}} {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				parallel(t)
+				willTopic := topicWithSuffix("cp7/test/mqisdp_will")
+				sub := confClient(t, addr)
+				confSubscribe(t, sub, 1, willTopic, 1)
+
+				conn := mustConnectMQIsdp(t, addr, &connectPacket{
+					CleanSession: true,
+					ClientID:     "mqisdp_lwt_" + randSuffix(),
+					WillFlag:     true,
+					WillQoS:      1,
+					WillTopic:    willTopic,
+					WillMessage:  []byte("mqisdp_gone"),
+				})
+				if tc.disconnect {
+					_ = conn.writePacket(&emptyPacket{Type: pktDISCONNECT})
+				}
+				conn.Close()
+
+				if tc.disconnect {
+					expectNoPublish(t, sub, 2*time.Second)
+					return
+				}
+				if p := confExpectPublish(t, sub); p.Topic != willTopic || string(p.Payload) != "mqisdp_gone" {
+					t.Fatalf("will delivered as %s %q, want %s mqisdp_gone", p.Topic, p.Payload, willTopic)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// 消息顺序与丢失校验：单个发布者在单个主题上发布编号序列，订阅端检查缺失、重复和乱序
+// (协议 Section 4.6)。每轮结果通过 t.Log 输出；设置 MQTT_ORDER_REPORT=path 时追加写入 JSON Lines。
+
+type sequenceReport struct {
+	Endpoint   string `json:"endpoint"`
+	Scenario   string `json:"scenario"`
+	QoS        byte   `json:"qos"`
+	Sent       int    `json:"sent"`
+	Received   int    `json:"received"`
+	Missing    int    `json:"missing"`
+	Duplicates int    `json:"duplicates"`
+	Reordered  int    `json:"reordered"`
+	FirstGap   int    `json:"first_gap"` // 第一个缺失的序号，无缺失为 -1
+}
+
+// analyzeSequence 统计收到的序号列表相对 0..sent-1 的缺失、重复和乱序。
+// 乱序指收到的序号小于此前已收到的最大序号 (且不是重复)。
+func analyzeSequence(got []string, sent int) sequenceReport {
+	r := sequenceReport{Sent: sent, Received: len(got), FirstGap: -1}
+	seen := make(map[int]bool, len(got))
+	maxSeq := -1
+	for _, s := range got {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			continue
+		}
+		if seen[n] {
+			r.Duplicates++
+			continue
+		}
+		seen[n] = true
+		if n < maxSeq {
+			r.Reordered++
+		} else {
+			maxSeq = n
+		}
+	}
+	for i := 0; i < sent; i++ {
+		if !seen[i] {
+			if r.FirstGap < 0 {
+				r.FirstGap = i
+			}
+			r.Missing++
+		}
+	}
+	return r
+}
+
+var orderReportMu sync.Mutex
+
+func writeOrderReport(t *testing.T, r sequenceReport) {
+	t.Logf("%s/%s QoS%d: sent=%d received=%d missing=%d (first gap %d) duplicates=%d reordered=%d",
+		r.Endpoint, r.Scenario, r.QoS, r.Sent, r.Received, r.Missing, r.FirstGap, r.Duplicates, r.Reordered)
+	path := getEnv("MQTT_ORDER_REPORT", "")
+	if path == "" {
+		return
+	}
+	line, _ := json.Marshal(r)
+	orderReportMu.Lock()
+	defer orderReportMu.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Errorf("write order report: %v", err)
+		return
+	}
+	defer f.Close()
+	_, _ = f.Write(append(line, '\n'))
+}
+
+// checkSequence 按 QoS 语义判定：QoS 0 允许丢失，QoS 1 允许重复，QoS 2 必须恰好一次；所有级别都不允许乱序。
+func checkSequence(t *testing.T, r sequenceReport) {
+	t.Helper()
+	writeOrderReport(t, r)
+	if r.Reordered > 0 {
+		t.Errorf("QoS%d: %d messages arrived out of order", r.QoS, r.Reordered)
+	}
+	if r.QoS > 0 && r.Missing > 0 {
+		t.Errorf("QoS%d: %d/%d messages lost, first gap at #%d", r.QoS, r.Missing, r.Sent, r.FirstGap)
+	}
+	if r.QoS != 1 && r.Duplicates > 0 {
+		t.Errorf("QoS%d: %d duplicate deliveries", r.QoS, r.Duplicates)
+	}
+}
+
+func publishSequence(t *testing.T, c mqtt.Client, topic string, qos byte, from, to int, interval time.Duration) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		tok := c.Publish(topic, qos, false, strconv.Itoa(i))
+		if qos > 0 {
+			mustWaitToken(t, tok, 10*time.Second, "publish #"+strconv.Itoa(i))
+		}
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+}
+
+// waitSequence 等待收到至少 n 条消息，然后再多等一小段时间以捕获重复投递。
+func waitSequence(rec *payloadRecorder, n int, timeout time.Duration) []string {
+	rec.waitCount(n, timeout)
+	time.Sleep(300 * time.Millisecond)
+	return rec.snapshot()
+}
+
+// testOrdering 在单个 endpoint 上运行顺序/丢失检查。
+func testOrdering(t *testing.T, ep brokerEndpoint) {
+	n := getEnvInt(t, "MQTT_ORDER_MESSAGES", 500)
+	interval := time.Duration(getEnvInt(t, "MQTT_ORDER_INTERVAL_MS", 0)) * time.Millisecond
+
+	for qos := byte(0); qos <= 2; qos++ {
+		qos := qos
+		t.Run("Sustained_QoS"+strconv.Itoa(int(qos)), func(t *testing.T) {
+			topic := topicWithSuffix("cp7/test/order")
+			rec := &payloadRecorder{}
+			sub := createClient(ep.url, ep.name+"_ordsub_"+randSuffix(), true)
+			mustConnect(t, sub, 5*time.Second)
+			defer sub.Disconnect(250)
+			mustWaitToken(t, sub.Subscribe(topic, qos, rec.handler), 5*time.Second, "subscribe")
+
+			pub := createClient(ep.url, ep.name+"_ordpub_"+randSuffix(), true)
+			mustConnect(t, pub, 5*time.Second)
+			defer pub.Disconnect(250)
+			publishSequence(t, pub, topic, qos, 0, n, interval)
+
+			r := analyzeSequence(waitSequence(rec, n, 15*time.Second), n)
+			r.Endpoint, r.Scenario, r.QoS = ep.name, "sustained", qos
+			checkSequence(t, r)
+		})
+	}
+
+	// 订阅者 (CleanSession=false) 在序列中途被强制断开 (直接关闭连接，不发送 DISCONNECT) 并重连，
+	// 离线期间的消息应按序补发
+	for qos := byte(1); qos <= 2; qos++ {
+		qos := qos
+		t.Run("Reconnect_QoS"+strconv.Itoa(int(qos)), func(t *testing.T) {
+			topic := topicWithSuffix("cp7/test/order_reconnect")
+			id := ep.name + "_ordre_" + randSuffix()
+			rec := &payloadRecorder{}
+			connect := func() (mqtt.Client, func()) {
+				opts := newClientOptions(ep.url, id, false)
+				opts.SetDefaultPublishHandler(rec.handler)
+				return connectKillable(t, opts)
+			}
+
+			cleanupSession(t, ep.url, id)
+			sub, kill := connect()
+			mustWaitToken(t, sub.Subscribe(topic, qos, rec.handler), 5*time.Second, "subscribe")
+
+			pub := createClient(ep.url, ep.name+"_ordrepub_"+randSuffix(), true)
+			mustConnect(t, pub, 5*time.Second)
+			defer pub.Disconnect(250)
+
+			third := n / 3
+			publishSequence(t, pub, topic, qos, 0, third, interval)
+			kill()
+			// 停掉旧客户端的自动重连和飞行中的 QoS 2 状态，避免与同 ClientId 的新客户端竞争
+			sub.Disconnect(0)
+			publishSequence(t, pub, topic, qos, third, 2*third, interval)
+			sub, _ = connect()
+			defer sub.Disconnect(250)
+			publishSequence(t, pub, topic, qos, 2*third, n, interval)
+
+			r := analyzeSequence(waitSequence(rec, n, 20*time.Second), n)
+			r.Endpoint, r.Scenario, r.QoS = ep.name, "reconnect", qos
+			checkSequence(t, r)
+		})
+	}
+}
+
+func TestMQTT_SequenceAnalysis(t *testing.T) {
+	r := analyzeSequence([]string{"0", "2", "1", "2", "4"}, 6)
+	if r.Missing != 2 || r.FirstGap != 3 || r.Duplicates != 1 || r.Reordered != 1 {
+		t.Fatalf("unexpected analysis: %+v", r)
+	}
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// MQTT 3.1.1 控制报文类型 (协议 Section 2.2.1)
+const (
+	pktCONNECT     byte = 1
+	pktCONNACK     byte = 2
+	pktPUBLISH     byte = 3
+	pktPUBACK      byte = 4
+	pktPUBREC      byte = 5
+	pktPUBREL      byte = 6
+	pktPUBCOMP     byte = 7
+	pktSUBSCRIBE   byte = 8
+	pktSUBACK      byte = 9
+	pktUNSUBSCRIBE byte = 10
+	pktUNSUBACK    byte = 11
+	pktPINGREQ     byte = 12
+	pktPINGRESP    byte = 13
+	pktDISCONNECT  byte = 14
+)
+
+// maxRemainingLength 是 4 字节变长编码能表示的最大剩余长度 (协议 Section 2.2.3)
+const maxRemainingLength = 268435455
+
+var packetNames = [...]string{
+	"RESERVED", "CONNECT", "CONNACK", "PUBLISH", "PUBACK", "PUBREC", "PUBREL", "PUBCOMP",
+	"SUBSCRIBE", "SUBACK", "UNSUBSCRIBE", "UNSUBACK", "PINGREQ", "PINGRESP", "DISCONNECT", "RESERVED",
+}
+
+func packetName(typ byte) string {
+	return packetNames[typ&0x0F]
+}
+
+var errMalformedRemainingLength = errors.New("mqtt: malformed remaining length")
+
+// mqttPacket 是一个可编码的 MQTT 3.1.1 控制报文。
+type mqttPacket interface {
+	packetType() byte
+	// flags 返回固定报头的低 4 位
+	flags() byte
+	// body 返回可变报头和有效载荷
+	body() []byte
+}
+
+// encodePacket 把报文编码为完整的帧 (固定报头 + 剩余长度 + 报文体)。
+func encodePacket(p mqttPacket) []byte {
+	return encodeFrame(p.packetType()<<4|p.flags(), p.body())
+}
+
+// encodeFrame 用任意首字节组帧，便于构造非法报文。
+func encodeFrame(header byte, body []byte) []byte {
+	out := make([]byte, 0, 5+len(body))
+	out = append(out, header)
+	out = appendRemainingLength(out, len(body))
+	return append(out, body...)
+}
+
+func appendRemainingLength(b []byte, n int) []byte {
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+func readRemainingLength(r io.ByteReader) (int, error) {
+	n, mult := 0, 1
+	for i := 0; i < 4; i++ {
+		d, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n += int(d&0x7F) * mult
+		if d&0x80 == 0 {
+			return n, nil
+		}
+		mult *= 128
+	}
+	return 0, errMalformedRemainingLength
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendBinary(b, []byte(s))
+}
+
+func appendBinary(b []byte, v []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(v)))
+	return append(b, v...)
+}
+
+func boolBit(v bool, bit byte) byte {
+	if v {
+		return bit
+	}
+	return 0
+}
+
+type connectPacket struct {
+	ProtocolName  string // 默认 "MQTT"
+	ProtocolLevel byte   // 默认 4
+	CleanSession  bool
+	KeepAlive     uint16
+	ClientID      string
+
+	WillFlag    bool
+	WillQoS     byte
+	WillRetain  bool
+	WillTopic   string
+	WillMessage []byte
+
+	UsernameFlag bool
+	Username     string
+	PasswordFlag bool
+	Password     []byte
+
+	// Reserved 置位连接标志的保留位，仅用于协议校验测试
+	Reserved bool
+
+	// Properties 仅在 ProtocolLevel 为 5 时编码 (见 mqtt_v5_packet_test.go)
+	Properties v5Properties
+}
+
+func (p *connectPacket) packetType() byte { return pktCONNECT }
+func (p *connectPacket) flags() byte      { return 0 }
+
+func (p *connectPacket) connectFlags() byte {
+	f := boolBit(p.Reserved, 0x01) | boolBit(p.CleanSession, 0x02)
+	if p.WillFlag {
+		f |= 0x04 | (p.WillQoS&0x03)<<3 | boolBit(p.WillRetain, 0x20)
+	}
+	return f | boolBit(p.PasswordFlag, 0x40) | boolBit(p.UsernameFlag, 0x80)
+}
+
+func (p *connectPacket) body() []byte {
+	name, level := p.ProtocolName, p.ProtocolLevel
+	if name == "" {
+		name = "MQTT"
+	}
+	if level == 0 {
+		level = 4
+	}
+	b := appendString(nil, name)
+	b = append(b, level, p.connectFlags())
+	b = binary.BigEndian.AppendUint16(b, p.KeepAlive)
+	if level == 5 {
+		b = p.Properties.append(b)
+	}
+	b = appendString(b, p.ClientID)
+	if p.WillFlag {
+		if level == 5 {
+			b = v5Properties(nil).append(b) // 遗嘱属性
+		}
+		b = appendString(b, p.WillTopic)
+		b = appendBinary(b, p.WillMessage)
+	}
+	if p.UsernameFlag {
+		b = appendString(b, p.Username)
+	}
+	if p.PasswordFlag {
+		b = appendBinary(b, p.Password)
+	}
+	return b
+}
+
+type connackPacket struct {
+	SessionPresent bool
+	ReturnCode     byte
+}
+
+func (p *connackPacket) packetType() byte { return pktCONNACK }
+func (p *connackPacket) flags() byte      { return 0 }
+func (p *connackPacket) body() []byte {
+	return []byte{boolBit(p.SessionPresent, 0x01), p.ReturnCode}
+}
+
+type publishPacket struct {
+	Dup      bool
+	QoS      byte
+	Retain   bool
+	Topic    string
+	PacketID uint16 // 仅 QoS > 0 时编码
+	Payload  []byte
+}
+
+func (p *publishPacket) packetType() byte { return pktPUBLISH }
+func (p *publishPacket) flags() byte {
+	return boolBit(p.Dup, 0x08) | (p.QoS&0x03)<<1 | boolBit(p.Retain, 0x01)
+}
+func (p *publishPacket) body() []byte {
+	b := appendString(nil, p.Topic)
+	if p.QoS > 0 {
+		b = binary.BigEndian.AppendUint16(b, p.PacketID)
+	}
+	return append(b, p.Payload...)
+}
+
+// ackPacket 覆盖只携带报文标识符的 PUBACK/PUBREC/PUBREL/PUBCOMP/UNSUBACK。
+type ackPacket struct {
+	Type     byte
+	PacketID uint16
+}
+
+func (p *ackPacket) packetType() byte { return p.Type }
+func (p *ackPacket) flags() byte {
+	if p.Type == pktPUBREL {
+		return 0x02
+	}
+	return 0
+}
+func (p *ackPacket) body() []byte {
+	return binary.BigEndian.AppendUint16(nil, p.PacketID)
+}
+
+type subscription struct {
+	Filter string
+	QoS    byte
+}
+
+type subscribePacket struct {
+	PacketID uint16
+	Topics   []subscription
+}
+
+func (p *subscribePacket) packetType() byte { return pktSUBSCRIBE }
+func (p *subscribePacket) flags() byte      { return 0x02 }
+func (p *subscribePacket) body() []byte {
+	b := binary.BigEndian.AppendUint16(nil, p.PacketID)
+	for _, s := range p.Topics {
+		b = appendString(b, s.Filter)
+		b = append(b, s.QoS)
+	}
+	return b
+}
+
+type subackPacket struct {
+	PacketID    uint16
+	ReturnCodes []byte
+}
+
+func (p *subackPacket) packetType() byte { return pktSUBACK }
+func (p *subackPacket) flags() byte      { return 0 }
+func (p *subackPacket) body() []byte {
+	return append(binary.BigEndian.AppendUint16(nil, p.PacketID), p.ReturnCodes...)
+}
+
+type unsubscribePacket struct {
+	PacketID uint16
+	Filters  []string
+}
+
+func (p *unsubscribePacket) packetType() byte { return pktUNSUBSCRIBE }
+func (p *unsubscribePacket) flags() byte      { return 0x02 }
+func (p *unsubscribePacket) body() []byte {
+	b := binary.BigEndian.AppendUint16(nil, p.PacketID)
+	for _, f := range p.Filters {
+		b = appendString(b, f)
+	}
+	return b
+}
+
+// emptyPacket 覆盖没有报文体的 PINGREQ/PINGRESP/DISCONNECT。
+type emptyPacket struct {
+	Type byte
+}
+
+func (p *emptyPacket) packetType() byte { return p.Type }
+func (p *emptyPacket) flags() byte      { return 0 }
+func (p *emptyPacket) body() []byte     { return nil }
+
+// bodyReader 按协议数据类型顺序读取报文体。
+type bodyReader struct {
+	b   []byte
+	err error
+}
+
+func (r *bodyReader) uint16() uint16 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.b) < 2 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.b)
+	r.b = r.b[2:]
+	return v
+}
+
+func (r *bodyReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.b) < 1 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *bodyReader) binary() []byte {
+	n := int(r.uint16())
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < n {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	v := r.b[:n:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *bodyReader) string() string {
+	return string(r.binary())
+}
+
+func (r *bodyReader) rest() []byte {
+	v := r.b
+	r.b = nil
+	return v
+}
+
+// decodePacket 解析一个完整报文 (首字节 + 报文体)，并校验固定报头的保留标志位。
+func decodePacket(header byte, body []byte) (mqttPacket, error) {
+	typ, fl := header>>4, header&0x0F
+	want := byte(0)
+	switch typ {
+	case pktPUBLISH:
+		want = fl
+	case pktPUBREL, pktSUBSCRIBE, pktUNSUBSCRIBE:
+		want = 0x02
+	}
+	if fl != want {
+		return nil, fmt.Errorf("mqtt: invalid flags %#x for %s", fl, packetName(typ))
+	}
+
+	r := &bodyReader{b: body}
+	var p mqttPacket
+	switch typ {
+	case pktCONNECT:
+		c := &connectPacket{}
+		c.ProtocolName = r.string()
+		c.ProtocolLevel = r.byte()
+		f := r.byte()
+		c.Reserved = f&0x01 != 0
+		c.CleanSession = f&0x02 != 0
+		c.WillFlag = f&0x04 != 0
+		c.WillQoS = (f >> 3) & 0x03
+		c.WillRetain = f&0x20 != 0
+		c.PasswordFlag = f&0x40 != 0
+		c.UsernameFlag = f&0x80 != 0
+		c.KeepAlive = r.uint16()
+		c.ClientID = r.string()
+		if c.WillFlag {
+			c.WillTopic = r.string()
+			c.WillMessage = r.binary()
+		}
+		if c.UsernameFlag {
+			c.Username = r.string()
+		}
+		if c.PasswordFlag {
+			c.Password = r.binary()
+		}
+		p = c
+	case pktCONNACK:
+		c := &connackPacket{}
+		c.SessionPresent = r.byte()&0x01 != 0
+		c.ReturnCode = r.byte()
+		p = c
+	case pktPUBLISH:
+		c := &publishPacket{Dup: fl&0x08 != 0, QoS: (fl >> 1) & 0x03, Retain: fl&0x01 != 0}
+		if c.QoS == 3 {
+			return nil, errors.New("mqtt: PUBLISH with QoS 3")
+		}
+		c.Topic = r.string()
+		if c.QoS > 0 {
+			c.PacketID = r.uint16()
+		}
+		c.Payload = r.rest()
+		p = c
+	case pktPUBACK, pktPUBREC, pktPUBREL, pktPUBCOMP, pktUNSUBACK:
+		p = &ackPacket{Type: typ, PacketID: r.uint16()}
+	case pktSUBSCRIBE:
+		c := &subscribePacket{PacketID: r.uint16()}
+		for r.err == nil && len(r.b) > 0 {
+			c.Topics = append(c.Topics, subscription{Filter: r.string(), QoS: r.byte()})
+		}
+		p = c
+	case pktSUBACK:
+		p = &subackPacket{PacketID: r.uint16(), ReturnCodes: r.rest()}
+	case pktUNSUBSCRIBE:
+		c := &unsubscribePacket{PacketID: r.uint16()}
+		for r.err == nil && len(r.b) > 0 {
+			c.Filters = append(c.Filters, r.string())
+		}
+		p = c
+	case pktPINGREQ, pktPINGRESP, pktDISCONNECT:
+		p = &emptyPacket{Type: typ}
+	default:
+		return nil, fmt.Errorf("mqtt: reserved packet type %d", typ)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("mqtt: decode %s: %w", packetName(typ), r.err)
+	}
+	if len(r.b) > 0 {
+		return nil, fmt.Errorf("mqtt: %d trailing bytes in %s", len(r.b), packetName(typ))
+	}
+	return p, nil
+}
+
+// readFrame 从流中读取一个完整帧，返回首字节和报文体。
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := readRemainingLength(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// splitFrames 把一段字节流切分为完整报文，末尾不完整的帧被忽略。
+func splitFrames(b []byte) ([]mqttPacket, error) {
+	var out []mqttPacket
+	r := bufio.NewReader(bytes.NewReader(b))
+	for {
+		header, body, err := readFrame(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+		p, err := decodePacket(header, body)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, p)
+	}
+}
+
+// packetConn 在 net.Conn 之上按帧读写 MQTT 报文，不依赖单次 Read 拿到完整报文。
+type packetConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newPacketConn(c net.Conn) *packetConn {
+	return &packetConn{Conn: c, r: bufio.NewReaderSize(c, 8192)}
+}
+
+func (c *packetConn) writePacket(p mqttPacket) error {
+	_, err := c.Write(encodePacket(p))
+	return err
+}
+
+// readFrame 在 timeout 内读取一个完整帧。
+func (c *packetConn) readFrame(timeout time.Duration) (byte, []byte, error) {
+	_ = c.SetReadDeadline(time.Now().Add(timeout))
+	defer c.SetReadDeadline(time.Time{})
+	return readFrame(c.r)
+}
+
+func (c *packetConn) readPacket(timeout time.Duration) (mqttPacket, error) {
+	header, body, err := c.readFrame(timeout)
+	if err != nil {
+		return nil, err
+	}
+	return decodePacket(header, body)
+}
+
+// expectPacket 读取下一个报文并断言其类型。
+func expectPacket[T mqttPacket](c *packetConn, timeout time.Duration) (T, error) {
+	var zero T
+	p, err := c.readPacket(timeout)
+	if err != nil {
+		return zero, err
+	}
+	v, ok := p.(T)
+	if !ok {
+		return zero, fmt.Errorf("expected %T, got %s", zero, packetName(p.packetType()))
+	}
+	return v, nil
+}
+
+// expectAck 读取下一个报文并断言其为指定类型的确认报文。
+func expectAck(c *packetConn, typ byte, id uint16, timeout time.Duration) error {
+	ack, err := expectPacket[*ackPacket](c, timeout)
+	if err != nil {
+		return err
+	}
+	if ack.Type != typ || ack.PacketID != id {
+		return fmt.Errorf("expected %s(%d), got %s(%d)", packetName(typ), id, packetName(ack.Type), ack.PacketID)
+	}
+	return nil
+}
+
+func TestMQTT_PacketCodec(t *testing.T) {
+	t.Run("RemainingLength_Boundaries", func(t *testing.T) {
+		// 协议 Section 2.2.3 表 2.4 的边界值
+		cases := []struct {
+			n   int
+			enc []byte
+		}{
+			{0, []byte{0x00}},
+			{127, []byte{0x7F}},
+			{128, []byte{0x80, 0x01}},
+			{16383, []byte{0xFF, 0x7F}},
+			{16384, []byte{0x80, 0x80, 0x01}},
+			{2097151, []byte{0xFF, 0xFF, 0x7F}},
+			{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+			{maxRemainingLength, []byte{0xFF, 0xFF, 0xFF, 0x7F}},
+		}
+		for _, tc := range cases {
+			got := appendRemainingLength(nil, tc.n)
+			if !bytes.Equal(got, tc.enc) {
+				t.Errorf("encode %d: got % x, want % x", tc.n, got, tc.enc)
+			}
+			n, err := readRemainingLength(bytes.NewReader(tc.enc))
+			if err != nil || n != tc.n {
+				t.Errorf("decode % x: got %d (%v), want %d", tc.enc, n, err, tc.n)
+			}
+		}
+		if _, err := readRemainingLength(bytes.NewReader([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0x7F})); err != errMalformedRemainingLength {
+			t.Errorf("5-byte remaining length: got %v, want %v", err, errMalformedRemainingLength)
+		}
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		long := bytes.Repeat([]byte("x"), 300) // 超过 127 字节，覆盖多字节剩余长度
+		pkts := []mqttPacket{
+			&connectPacket{ProtocolName: "MQTT", ProtocolLevel: 4, CleanSession: true, KeepAlive: 60, ClientID: string(long),
+				WillFlag: true, WillQoS: 1, WillRetain: true, WillTopic: "will/t", WillMessage: []byte("bye"),
+				UsernameFlag: true, Username: "u", PasswordFlag: true, Password: []byte("p")},
+			&connackPacket{SessionPresent: true, ReturnCode: 0},
+			&publishPacket{Topic: "a/b", Payload: long},
+			&publishPacket{Dup: true, QoS: 2, Retain: true, Topic: "a/b", PacketID: 7, Payload: []byte{}},
+			&ackPacket{Type: pktPUBACK, PacketID: 1},
+			&ackPacket{Type: pktPUBREC, PacketID: 2},
+			&ackPacket{Type: pktPUBREL, PacketID: 3},
+			&ackPacket{Type: pktPUBCOMP, PacketID: 4},
+			&subscribePacket{PacketID: 5, Topics: []subscription{{"a/+", 1}, {"b/#", 2}}},
+			&subackPacket{PacketID: 5, ReturnCodes: []byte{1, 0x80}},
+			&unsubscribePacket{PacketID: 6, Filters: []string{"a/+", "b/#"}},
+			&ackPacket{Type: pktUNSUBACK, PacketID: 6},
+			&emptyPacket{Type: pktPINGREQ},
+			&emptyPacket{Type: pktPINGRESP},
+			&emptyPacket{Type: pktDISCONNECT},
+		}
+		var stream []byte
+		for _, p := range pkts {
+			stream = append(stream, encodePacket(p)...)
+		}
+		got, err := splitFrames(stream)
+		if err != nil {
+			t.Fatalf("decode stream: %v", err)
+		}
+		if len(got) != len(pkts) {
+			t.Fatalf("decoded %d packets, want %d", len(got), len(pkts))
+		}
+		for i := range pkts {
+			if a, b := encodePacket(got[i]), encodePacket(pkts[i]); !bytes.Equal(a, b) {
+				t.Errorf("%s: re-encoded % x, want % x", packetName(pkts[i].packetType()), a, b)
+			}
+		}
+	})
+
+	t.Run("Reject_ReservedFlags", func(t *testing.T) {
+		if _, err := decodePacket(pktPUBREL<<4, []byte{0, 1}); err == nil {
+			t.Error("PUBREL with flags 0 should be rejected")
+		}
+		if _, err := decodePacket(pktPUBLISH<<4|0x06, []byte{0, 1, 'a', 0, 1}); err == nil {
+			t.Error("PUBLISH with QoS 3 should be rejected")
+		}
+	})
+}
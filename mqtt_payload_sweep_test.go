@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// 载荷大小扫描：在每个 endpoint 上按 QoS 0/1/2 发布 1 字节到最大允许载荷 (按 2 的幂递增，最后一档为最大值)，
+// 订阅端校验长度和 SHA-256，记录每个 QoS 完整送达的最大载荷以及失败的位置和原因。
+// 大载荷走 TCP 监听器的写缓冲/背压路径，历史上在这里出现过截断和断连。
+// 设置了 MQTT_MAX_PACKET 时最大值取自 Broker 的最大报文大小，否则扫描到 MQTT_DELIVER_MIN (默认 64KB)；
+// 设置 MQTT_PAYLOAD_REPORT=path 时以 JSON Lines 追加写入每一档的结果。
+
+// 投递失败原因
+const (
+	sweepPublishTimeout = "publish timeout"
+	sweepPublishError   = "publish error"
+	sweepSubscriberLost = "subscriber lost connection"
+	sweepNotDelivered   = "not delivered"
+	sweepLengthMismatch = "length mismatch"
+	sweepChecksum       = "checksum mismatch"
+)
+
+type sweepResult struct {
+	Endpoint string `json:"endpoint"`
+	QoS      byte   `json:"qos"`
+	Size     int    `json:"size"`
+	OK       bool   `json:"ok"`
+	Reason   string `json:"reason,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Millis   int64  `json:"ms"`
+}
+
+// sweepSizes 返回 1, 2, 4, ... 直到 maxSize (包含 maxSize 本身)。
+func sweepSizes(maxSize int) []int {
+	var sizes []int
+	for n := 1; n < maxSize; n *= 2 {
+		sizes = append(sizes, n)
+	}
+	return append(sizes, maxSize)
+}
+
+// deliverPayload 用新建的连接发布一条 size 字节的随机载荷并校验订阅端收到的内容。
+func deliverPayload(t *testing.T, ep brokerEndpoint, qos byte, size int) sweepResult {
+	r := sweepResult{Endpoint: ep.name, QoS: qos, Size: size}
+	start := time.Now()
+	defer func() { r.Millis = time.Since(start).Milliseconds() }()
+
+	topic := topicWithSuffix("cp7/test/sweep")
+	payload := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(payload)
+	want := sha256.Sum256(payload)
+
+	subLost := make(chan error, 1)
+	subOpts := newClientOptions(ep.url, ep.name+"_swsub_"+randSuffix(), true)
+	subOpts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		select {
+		case subLost <- err:
+		default:
+		}
+	})
+	sub := mqtt.NewClient(subOpts)
+	mustConnect(t, sub, 5*time.Second)
+	defer sub.Disconnect(250)
+
+	got := make(chan []byte, 1)
+	var once sync.Once
+	mustWaitToken(t, sub.Subscribe(topic, qos, func(client mqtt.Client, msg mqtt.Message) {
+		once.Do(func() { got <- msg.Payload() })
+	}), 5*time.Second, "subscribe")
+
+	pub := createClient(ep.url, ep.name+"_swpub_"+randSuffix(), true)
+	mustConnect(t, pub, 5*time.Second)
+	defer pub.Disconnect(250)
+
+	tok := pub.Publish(topic, qos, false, payload)
+	if !tok.WaitTimeout(30 * time.Second) {
+		r.Reason = sweepPublishTimeout
+		return r
+	}
+	if err := tok.Error(); err != nil {
+		r.Reason, r.Detail = sweepPublishError, err.Error()
+		return r
+	}
+
+	select {
+	case p := <-got:
+		switch {
+		case len(p) != size:
+			r.Reason, r.Detail = sweepLengthMismatch, "got "+strconv.Itoa(len(p))
+		case sha256.Sum256(p) != want:
+			r.Reason = sweepChecksum
+		default:
+			r.OK = true
+		}
+	case err := <-subLost:
+		r.Reason, r.Detail = sweepSubscriberLost, err.Error()
+	case <-time.After(30 * time.Second):
+		r.Reason = sweepNotDelivered
+	}
+	return r
+}
+
+var payloadReportMu sync.Mutex
+
+// writeSweepReport 以表格形式输出扫描结果，并按需追加写入 MQTT_PAYLOAD_REPORT。
+func writeSweepReport(t *testing.T, results []sweepResult) {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENDPOINT\tQOS\tSIZE\tRESULT\tREASON\tMS")
+	for _, r := range results {
+		res := "ok"
+		if !r.OK {
+			res = "FAIL"
+		}
+		reason := r.Reason
+		if r.Detail != "" {
+			reason += ": " + r.Detail
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%d\n", r.Endpoint, r.QoS, r.Size, res, reason, r.Millis)
+	}
+	tw.Flush()
+	t.Log("\n" + b.String())
+
+	path := getEnv("MQTT_PAYLOAD_REPORT", "")
+	if path == "" {
+		return
+	}
+	payloadReportMu.Lock()
+	defer payloadReportMu.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Errorf("write payload report: %v", err)
+		return
+	}
+	defer f.Close()
+	for _, r := range results {
+		line, _ := json.Marshal(r)
+		_, _ = f.Write(append(line, '\n'))
+	}
+}
+
+func testPayloadSweep(t *testing.T, eps []brokerEndpoint) {
+	if testing.Short() {
+		t.Skip("payload sweep skipped in -short mode")
+	}
+	maxPayload := getEnvInt(t, "MQTT_DELIVER_MIN", 64*1024)
+	if maxPacket := getEnvInt(t, "MQTT_MAX_PACKET", 0); maxPacket > 0 {
+		// 最大载荷：整包 (含最长的测试主题) 恰好等于最大报文大小
+		p, err := publishOfSize(topicWithSuffix("cp7/test/sweep"), 1, maxPacket)
+		if err != nil {
+			t.Skip(err)
+		}
+		maxPayload = len(p.Payload)
+	}
+	sizes := sweepSizes(maxPayload)
+
+	for _, ep := range eps {
+		ep := ep
+		t.Run(ep.name, func(t *testing.T) {
+			var results []sweepResult
+			defer func() { writeSweepReport(t, results) }()
+
+			for qos := byte(0); qos <= 2; qos++ {
+				// largest 只统计第一次失败之前的档位，之后的档位仍然执行并写入报告
+				largest, failed := 0, false
+				for _, size := range sizes {
+					r := deliverPayload(t, ep, qos, size)
+					results = append(results, r)
+					if !r.OK {
+						t.Errorf("%s QoS%d: %d-byte payload failed: %s %s", ep.name, qos, size, r.Reason, r.Detail)
+						failed = true
+						continue
+					}
+					if !failed {
+						largest = size
+					}
+				}
+				t.Logf("%s QoS%d: largest payload delivered intact %d/%d bytes", ep.name, qos, largest, maxPayload)
+			}
+		})
+	}
+}
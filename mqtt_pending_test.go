@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+// 待 Broker 支持的用例：MQTT 5.0、MQIsdp、主题别名、消息过期和会话过期依赖 Broker 本身尚未实现的功能
+// (本仓库不包含 Broker 源码)，这些需求处于阻塞状态，不计入功能测试。为它们准备的用例集中挂在 TestMQTT_PendingBrokerSupport 下，
+// 默认跳过；Broker 实现后设置 MQTT_PENDING=1 运行，各组在 Broker 仍不支持时自行跳过。
+
+func TestMQTT_PendingBrokerSupport(t *testing.T) {
+	if getEnv("MQTT_PENDING", "") != "1" {
+		t.Skip("blocked on broker support; set MQTT_PENDING=1 to run")
+	}
+	eps := mustEndpoints(t)
+
+	for _, ep := range eps {
+		ep := ep
+		t.Run(ep.name, func(t *testing.T) {
+			// MQTT 5.0 变体 (paho.golang)
+			t.Run("MQTT5", func(t *testing.T) {
+				parallel(t)
+				testMQTT5(t, ep)
+			})
+		})
+	}
+
+	t.Run("TCP_Only", func(t *testing.T) {
+		parallel(t)
+		tcp := tcpEndpoint(t, eps)
+
+		// MQTT 3.1 (MQIsdp) 旧客户端兼容性
+		t.Run("MQIsdp_V31", func(t *testing.T) {
+			parallel(t)
+			testMQIsdp(t, tcp)
+		})
+
+		// MQTT 5.0 主题别名 (原生报文)
+		t.Run("MQTT5_Topic_Alias", func(t *testing.T) {
+			parallel(t)
+			testTopicAlias(t, tcp)
+		})
+
+		// 离线队列和保留消息的过期
+		t.Run("Message_Expiry", func(t *testing.T) {
+			parallel(t)
+			testMessageExpiry(t, tcp)
+		})
+
+		// 持久会话的过期与清理
+		t.Run("Session_Expiry", func(t *testing.T) {
+			parallel(t)
+			testSessionExpiry(t, tcp)
+		})
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// payloadRecorder 按到达顺序记录收到的消息载荷。
+type payloadRecorder struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (r *payloadRecorder) handler(client mqtt.Client, msg mqtt.Message) {
+	r.mu.Lock()
+	r.msgs = append(r.msgs, string(msg.Payload()))
+	r.mu.Unlock()
+}
+
+func (r *payloadRecorder) snapshot() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.msgs...)
+}
+
+// waitCount 等待至少收到 n 条消息，超时返回 false。
+func (r *payloadRecorder) waitCount(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		r.mu.Lock()
+		got := len(r.msgs)
+		r.mu.Unlock()
+		if got >= n {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// connectSession 以给定 CleanSession 连接，所有未被显式订阅处理的消息都进入 recorder，
+// 返回 CONNACK 中的 session present 标志。
+func connectSession(t *testing.T, url, id string, clean bool) (mqtt.Client, *payloadRecorder, bool) {
+	t.Helper()
+	rec := &payloadRecorder{}
+	opts := newClientOptions(url, id, clean)
+	opts.SetDefaultPublishHandler(rec.handler)
+	c := mqtt.NewClient(opts)
+	tok := c.Connect()
+	mustWaitToken(t, tok, 5*time.Second, "connect")
+	present := false
+	if ct, ok := tok.(*mqtt.ConnectToken); ok {
+		present = ct.SessionPresent()
+	}
+	return c, rec, present
+}
+
+// testPersistentSession 覆盖 benchmark/persistent_session_test.sh 的检查项：
+// 首次连接的节点为 first，重连的节点为 second (单节点时两者相同)，发布者连接 pubURL。
+func testPersistentSession(t *testing.T, first, second, pubURL string) {
+	publish := func(t *testing.T, topic string, payloads ...string) {
+		t.Helper()
+		p := createClient(pubURL, "ps_pub_"+randSuffix(), true)
+		mustConnect(t, p, 5*time.Second)
+		defer p.Disconnect(250)
+		for _, pl := range payloads {
+			mustWaitToken(t, p.Publish(topic, 1, false, pl), 10*time.Second, "publish")
+		}
+	}
+
+	// 建立持久会话并订阅，然后正常断开
+	establish := func(t *testing.T, id, topic string) {
+		t.Helper()
+		cleanupSession(t, second, id)
+		c, _, present := connectSession(t, first, id, false)
+		if present {
+			t.Errorf("session present = true on first connect of %s", id)
+		}
+		mustWaitToken(t, c.Subscribe(topic, 1, nil), 5*time.Second, "subscribe")
+		c.Disconnect(250)
+	}
+
+	t.Run("Subscription_Survives_Reconnect", func(t *testing.T) {
+		id := "ps_resub_" + randSuffix()
+		topic := topicWithSuffix("persistent/session/test")
+		payload := "auto_resubscribe_" + randSuffix()
+		establish(t, id, topic)
+
+		// 重连后不调用 Subscribe，消息应通过持久订阅送达
+		c, rec, _ := connectSession(t, second, id, false)
+		defer c.Disconnect(250)
+		publish(t, topic, payload)
+		if !rec.waitCount(1, 10*time.Second) || rec.snapshot()[0] != payload {
+			t.Fatalf("persistent subscription not restored, got %v", rec.snapshot())
+		}
+	})
+
+	t.Run("SessionPresent_Flag", func(t *testing.T) {
+		id := "ps_present_" + randSuffix()
+		establish(t, id, topicWithSuffix("persistent/session/present"))
+
+		c, _, present := connectSession(t, second, id, false)
+		defer c.Disconnect(250)
+		if !present {
+			t.Fatal("CONNACK session present = false for an existing CleanSession=false session")
+		}
+	})
+
+	t.Run("QoS1_Backlog_Order", func(t *testing.T) {
+		id := "ps_backlog_" + randSuffix()
+		topic := topicWithSuffix("persistent/session/backlog")
+		establish(t, id, topic)
+
+		n := getEnvInt(t, "MQTT_BACKLOG_MESSAGES", 20)
+		want := make([]string, n)
+		for i := range want {
+			want[i] = "backlog_" + strconv.Itoa(i)
+		}
+		publish(t, topic, want...)
+
+		c, rec, _ := connectSession(t, second, id, false)
+		defer c.Disconnect(250)
+		rec.waitCount(n, 15*time.Second)
+		if got := rec.snapshot(); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("backlog not drained in publish order:\n got  %v\n want %v", got, want)
+		}
+	})
+
+	t.Run("CleanSession_True_Wipes", func(t *testing.T) {
+		id := "ps_clean_" + randSuffix()
+		topic := topicWithSuffix("persistent/session/clean")
+		establish(t, id, topic)
+		publish(t, topic, "queued_before_clean")
+
+		// CleanSession=true 连接应丢弃会话和积压消息
+		c, rec, present := connectSession(t, second, id, true)
+		if present {
+			t.Error("session present = true for CleanSession=true connect")
+		}
+		time.Sleep(500 * time.Millisecond)
+		c.Disconnect(250)
+		if got := rec.snapshot(); len(got) != 0 {
+			t.Errorf("CleanSession=true connect received old session messages: %v", got)
+		}
+
+		c2, rec2, present2 := connectSession(t, second, id, false)
+		defer c2.Disconnect(250)
+		if present2 {
+			t.Error("session present = true after session was cleaned")
+		}
+		publish(t, topic, "after_clean")
+		time.Sleep(time.Second)
+		if got := rec2.snapshot(); len(got) != 0 {
+			t.Fatalf("subscription or backlog survived CleanSession=true: %v", got)
+		}
+	})
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// QoS 2 握手中断恢复 (协议 Section 4.3.3 / 4.4)：在握手的每个阶段直接断开 TCP，
+// 以 CleanSession=false 重连后校验重传规则，并确认应用层只收到一次消息。
+//
+// 入站 (客户端 -> Broker)：客户端重连后重发 DUP=1 的 PUBLISH 或 PUBREL，Broker 不得重复分发。
+// 出站 (Broker -> 客户端)：Broker 重连后必须重发 DUP=1 的 PUBLISH (未收到 PUBREC 时) 或 PUBREL (已收到 PUBREC 时)。
+
+const qos2PacketID uint16 = 0x1234
+
+// rawSessionConnect 以 CleanSession=false 建立原生连接并校验 CONNACK。
+func rawSessionConnect(t *testing.T, addr, id string, wantPresent bool) *packetConn {
+	t.Helper()
+	conn, ack, err := rawConnect(addr, &connectPacket{KeepAlive: 60, ClientID: id}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("raw connect %s: %v", id, err)
+	}
+	if ack.ReturnCode != 0 {
+		conn.Close()
+		t.Fatalf("CONNACK refused: return code %#x", ack.ReturnCode)
+	}
+	if ack.SessionPresent != wantPresent {
+		t.Errorf("session present = %v, want %v", ack.SessionPresent, wantPresent)
+	}
+	return conn
+}
+
+// rawSessionCleanup 用 CleanSession=true 连接一次以删除持久会话。
+func rawSessionCleanup(addr, id string) {
+	if conn, _, err := rawConnect(addr, &connectPacket{CleanSession: true, KeepAlive: 60, ClientID: id}, 5*time.Second); err == nil {
+		_ = conn.writePacket(&emptyPacket{Type: pktDISCONNECT})
+		conn.Close()
+	}
+}
+
+func mustExpectAck(t *testing.T, conn *packetConn, typ byte, id uint16) {
+	t.Helper()
+	if err := expectAck(conn, typ, id, 5*time.Second); err != nil {
+		t.Fatalf("waiting for %s: %v", packetName(typ), err)
+	}
+}
+
+// expectCompAfterRel 等待 PUBCOMP；重连后 Broker 可能重发同一 ID 的 PUBREC，此时忽略即可。
+func expectCompAfterRel(t *testing.T, conn *packetConn, id uint16) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p, err := conn.readPacket(time.Until(deadline))
+		if err != nil {
+			t.Fatalf("waiting for PUBCOMP: %v", err)
+		}
+		ack, ok := p.(*ackPacket)
+		if ok && ack.Type == pktPUBREC && ack.PacketID == id {
+			continue
+		}
+		if !ok || ack.Type != pktPUBCOMP || ack.PacketID != id {
+			t.Fatalf("waiting for PUBCOMP(%d): got %s", id, packetName(p.packetType()))
+		}
+		return
+	}
+}
+
+// expectNoPublish 在 d 时间内不应再收到任何报文 (尤其是重复的 PUBLISH)。
+func expectNoPublish(t *testing.T, conn *packetConn, d time.Duration) {
+	t.Helper()
+	if p, err := conn.readPacket(d); err == nil {
+		t.Fatalf("unexpected %s", packetName(p.packetType()))
+	}
+}
+
+func testQoS2Interrupted(t *testing.T, tcp string) {
+	addr := tcpAddrFromMQTTURL(tcp)
+
+	// 入站：发布者在各阶段断开，应用订阅者只应收到一次
+	inbound := []struct {
+		name string
+		// interrupt 执行断开前的握手步骤；resume 在重连后完成握手
+		interrupt func(t *testing.T, c *packetConn, pub *publishPacket)
+		resume    func(t *testing.T, c *packetConn, pub *publishPacket)
+	}{
+		{
+			name: "Inbound_After_PUBLISH",
+			interrupt: func(t *testing.T, c *packetConn, pub *publishPacket) {
+				_ = c.writePacket(pub)
+			},
+			resume: func(t *testing.T, c *packetConn, pub *publishPacket) {
+				dup := *pub
+				dup.Dup = true
+				_ = c.writePacket(&dup)
+				mustExpectAck(t, c, pktPUBREC, pub.PacketID)
+				_ = c.writePacket(&ackPacket{Type: pktPUBREL, PacketID: pub.PacketID})
+				expectCompAfterRel(t, c, pub.PacketID)
+			},
+		},
+		{
+			name: "Inbound_After_PUBREC",
+			interrupt: func(t *testing.T, c *packetConn, pub *publishPacket) {
+				_ = c.writePacket(pub)
+				mustExpectAck(t, c, pktPUBREC, pub.PacketID)
+			},
+			resume: func(t *testing.T, c *packetConn, pub *publishPacket) {
+				_ = c.writePacket(&ackPacket{Type: pktPUBREL, PacketID: pub.PacketID})
+				expectCompAfterRel(t, c, pub.PacketID)
+			},
+		},
+		{
+			name: "Inbound_After_PUBREL",
+			interrupt: func(t *testing.T, c *packetConn, pub *publishPacket) {
+				_ = c.writePacket(pub)
+				mustExpectAck(t, c, pktPUBREC, pub.PacketID)
+				_ = c.writePacket(&ackPacket{Type: pktPUBREL, PacketID: pub.PacketID})
+			},
+			resume: func(t *testing.T, c *packetConn, pub *publishPacket) {
+				_ = c.writePacket(&ackPacket{Type: pktPUBREL, PacketID: pub.PacketID})
+				expectCompAfterRel(t, c, pub.PacketID)
+			},
+		},
+	}
+
+	for _, tc := range inbound {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			topic := topicWithSuffix("cp7/test/q2in")
+			payload := "q2_in_" + randSuffix()
+			id := "q2in_" + randSuffix()
+			defer rawSessionCleanup(addr, id)
+
+			var deliveries atomic.Int32
+			sub := createClient(tcp, "q2in_sub_"+randSuffix(), true)
+			mustConnect(t, sub, 5*time.Second)
+			defer sub.Disconnect(250)
+			mustWaitToken(t, sub.Subscribe(topic, 2, func(client mqtt.Client, msg mqtt.Message) {
+				if string(msg.Payload()) == payload {
+					deliveries.Add(1)
+				}
+			}), 5*time.Second, "subscribe")
+
+			pub := &publishPacket{QoS: 2, Topic: topic, PacketID: qos2PacketID, Payload: []byte(payload)}
+			c := rawSessionConnect(t, addr, id, false)
+			tc.interrupt(t, c, pub)
+			c.Close()
+			time.Sleep(300 * time.Millisecond)
+
+			c = rawSessionConnect(t, addr, id, true)
+			defer c.Close()
+			tc.resume(t, c, pub)
+
+			time.Sleep(time.Second)
+			if n := deliveries.Load(); n != 1 {
+				t.Fatalf("subscriber received %d copies, want exactly 1", n)
+			}
+		})
+	}
+
+	// 出站：订阅者在各阶段断开，Broker 按规则重传
+	outbound := []struct {
+		name      string
+		interrupt func(t *testing.T, c *packetConn) uint16
+		resume    func(t *testing.T, c *packetConn, id uint16, payload string)
+	}{
+		{
+			name: "Outbound_After_PUBLISH",
+			interrupt: func(t *testing.T, c *packetConn) uint16 {
+				p, err := expectPacket[*publishPacket](c, 5*time.Second)
+				if err != nil {
+					t.Fatalf("waiting for PUBLISH: %v", err)
+				}
+				return p.PacketID
+			},
+			resume: func(t *testing.T, c *packetConn, id uint16, payload string) {
+				p, err := expectPacket[*publishPacket](c, 5*time.Second)
+				if err != nil {
+					t.Fatalf("PUBLISH not retransmitted after reconnect: %v", err)
+				}
+				if !p.Dup || p.PacketID != id || p.QoS != 2 || string(p.Payload) != payload {
+					t.Fatalf("retransmitted PUBLISH dup=%v id=%d qos=%d payload=%q, want dup=true id=%d qos=2 payload=%q",
+						p.Dup, p.PacketID, p.QoS, p.Payload, id, payload)
+				}
+				_ = c.writePacket(&ackPacket{Type: pktPUBREC, PacketID: id})
+				mustExpectAck(t, c, pktPUBREL, id)
+				_ = c.writePacket(&ackPacket{Type: pktPUBCOMP, PacketID: id})
+			},
+		},
+		{
+			name: "Outbound_After_PUBREC",
+			interrupt: func(t *testing.T, c *packetConn) uint16 {
+				p, err := expectPacket[*publishPacket](c, 5*time.Second)
+				if err != nil {
+					t.Fatalf("waiting for PUBLISH: %v", err)
+				}
+				_ = c.writePacket(&ackPacket{Type: pktPUBREC, PacketID: p.PacketID})
+				return p.PacketID
+			},
+			resume: func(t *testing.T, c *packetConn, id uint16, payload string) {
+				mustExpectAck(t, c, pktPUBREL, id)
+				_ = c.writePacket(&ackPacket{Type: pktPUBCOMP, PacketID: id})
+			},
+		},
+		{
+			name: "Outbound_After_PUBREL",
+			interrupt: func(t *testing.T, c *packetConn) uint16 {
+				p, err := expectPacket[*publishPacket](c, 5*time.Second)
+				if err != nil {
+					t.Fatalf("waiting for PUBLISH: %v", err)
+				}
+				_ = c.writePacket(&ackPacket{Type: pktPUBREC, PacketID: p.PacketID})
+				mustExpectAck(t, c, pktPUBREL, p.PacketID)
+				return p.PacketID
+			},
+			resume: func(t *testing.T, c *packetConn, id uint16, payload string) {
+				mustExpectAck(t, c, pktPUBREL, id)
+				_ = c.writePacket(&ackPacket{Type: pktPUBCOMP, PacketID: id})
+			},
+		},
+	}
+
+	for _, tc := range outbound {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			topic := topicWithSuffix("cp7/test/q2out")
+			payload := "q2_out_" + randSuffix()
+			id := "q2out_" + randSuffix()
+			defer rawSessionCleanup(addr, id)
+
+			c := rawSessionConnect(t, addr, id, false)
+			_ = c.writePacket(&subscribePacket{PacketID: 1, Topics: []subscription{{Filter: topic, QoS: 2}}})
+			if ack, err := expectPacket[*subackPacket](c, 5*time.Second); err != nil || len(ack.ReturnCodes) != 1 || ack.ReturnCodes[0] != 2 {
+				t.Fatalf("SUBACK: %+v, %v", ack, err)
+			}
+
+			pub := createClient(tcp, "q2out_pub_"+randSuffix(), true)
+			mustConnect(t, pub, 5*time.Second)
+			defer pub.Disconnect(250)
+			mustWaitToken(t, pub.Publish(topic, 2, false, payload), 10*time.Second, "publish")
+
+			pid := tc.interrupt(t, c)
+			c.Close()
+			time.Sleep(300 * time.Millisecond)
+
+			c = rawSessionConnect(t, addr, id, true)
+			defer c.Close()
+			tc.resume(t, c, pid, payload)
+			expectNoPublish(t, c, time.Second)
+		})
+	}
+}
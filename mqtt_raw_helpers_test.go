@@ -1,42 +1,54 @@
 package main
 
 import (
-	"bytes"
 	"net"
+	"strings"
 	"time"
 )
 
-func tcpDial(addr string, timeout time.Duration) (net.Conn, error) {
-	return net.DialTimeout("tcp", addr, timeout)
+// tcpDial 建立原生 TCP 连接，并包装为按帧读写的 packetConn。
+func tcpDial(addr string, timeout time.Duration) (*packetConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return newPacketConn(conn), nil
 }
 
 func tcpAddrFromMQTTURL(mqttURL string) string {
-	// Supports: tcp://host:port
-	const p = "tcp://"
-	if len(mqttURL) >= len(p) && mqttURL[:len(p)] == p {
-		return mqttURL[len(p):]
+	// Supports: tcp://host:port, ssl://host:port (and tls://, mqtts://, tcps://)
+	for _, p := range []string{"tcp://", "ssl://", "tls://", "mqtts://", "tcps://"} {
+		if strings.HasPrefix(mqttURL, p) {
+			return mqttURL[len(p):]
+		}
 	}
 	return mqttURL
 }
 
-func mustReadSome(conn net.Conn, timeout time.Duration) ([]byte, error) {
-	_ = conn.SetReadDeadline(time.Now().Add(timeout))
-	buf := make([]byte, 4096)
-	n, err := conn.Read(buf)
-	if n > 0 {
-		return buf[:n], nil
-	}
-	return nil, err
+// rawConnect 建立原生连接并完成 CONNECT/CONNACK 握手，返回解码后的 CONNACK。
+// p 未显式设置用户名/密码时自动附加测试凭据 (见 mqtt_auth_test.go)。
+func rawConnect(addr string, p *connectPacket, timeout time.Duration) (*packetConn, *connackPacket, error) {
+	applyCredentials(p)
+	return rawHandshake(addr, p, timeout)
 }
 
-func containsAny(b []byte, subs ...[]byte) bool {
-	for _, s := range subs {
-		if len(s) == 0 {
-			continue
-		}
-		if bytes.Contains(b, s) {
-			return true
-		}
+// rawHandshake 与 rawConnect 相同，但按原样发送 p，用于认证测试。
+func rawHandshake(addr string, p *connectPacket, timeout time.Duration) (*packetConn, *connackPacket, error) {
+	conn, err := tcpDial(addr, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !p.CleanSession && p.ClientID != "" {
+		persistentSessions.Store(p.ClientID, "tcp://"+addr)
+	}
+	if err := conn.writePacket(p); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	ack, err := expectPacket[*connackPacket](conn, timeout)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
 	}
-	return false
+	return conn, ack, nil
 }
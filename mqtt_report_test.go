@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// 机器可读的测试报告：设置 MQTT_REPORT=path 时，TestMain 在测试结束后写出 path.xml (JUnit) 和
+// path.json。每个叶子子测试一条记录，包含耗时、所属 endpoint 及其 URL、失败/跳过信息；报告头部
+// 记录从 $SYS/broker/version 读取的 Broker 版本。
+//
+// 结果来自 go test -v 的输出：开启报告时 TestMain 强制 -test.v 并把标准输出同时写入解析器。
+
+type reportCase struct {
+	Name     string   `json:"name"`
+	Endpoint string   `json:"endpoint,omitempty"`
+	URL      string   `json:"url,omitempty"`
+	Status   string   `json:"status"` // pass / fail / skip
+	Seconds  float64  `json:"duration_seconds"`
+	Output   []string `json:"output,omitempty"`
+}
+
+type testReport struct {
+	BrokerVersion string            `json:"broker_version"`
+	Endpoints     map[string]string `json:"endpoints"`
+	Started       time.Time         `json:"started"`
+	Seconds       float64           `json:"duration_seconds"`
+	Tests         []*reportCase     `json:"tests"`
+
+	byName  map[string]*reportCase
+	current string
+}
+
+var (
+	reportRunLine    = regexp.MustCompile(`^=== (?:RUN|NAME|CONT|PAUSE)\s+(\S+)`)
+	reportResultLine = regexp.MustCompile(`^\s*--- (PASS|FAIL|SKIP): (\S+) \(([\d.]+)s\)`)
+)
+
+func newTestReport(eps []brokerEndpoint) *testReport {
+	r := &testReport{Endpoints: map[string]string{}, Started: time.Now(), byName: map[string]*reportCase{}}
+	for _, ep := range eps {
+		r.Endpoints[ep.name] = ep.url
+	}
+	return r
+}
+
+func (r *testReport) lookup(name string) *reportCase {
+	c, ok := r.byName[name]
+	if !ok {
+		c = &reportCase{Name: name}
+		// 子测试路径中与 endpoint 同名的一级 (如 TestMQTT_Functional_Full/tcp/...) 即所属 endpoint
+		for _, part := range strings.Split(name, "/")[1:] {
+			if url, ok := r.Endpoints[part]; ok {
+				c.Endpoint, c.URL = part, url
+				break
+			}
+		}
+		r.byName[name] = c
+		r.Tests = append(r.Tests, c)
+	}
+	return c
+}
+
+// parseLine 处理一行 -v 输出；test2json 模式下的 0x16 帧标记会被去掉。
+func (r *testReport) parseLine(line string) {
+	line = strings.ReplaceAll(line, "\x16", "")
+	if m := reportRunLine.FindStringSubmatch(line); m != nil {
+		r.current = m[1]
+		r.lookup(m[1])
+		return
+	}
+	if m := reportResultLine.FindStringSubmatch(line); m != nil {
+		c := r.lookup(m[2])
+		c.Status = strings.ToLower(m[1])
+		c.Seconds, _ = strconv.ParseFloat(m[3], 64)
+		return
+	}
+	// t.Log/t.Error 的输出带缩进，归属于最近一次 RUN/NAME 的测试
+	if r.current != "" && strings.HasPrefix(line, "    ") {
+		c := r.lookup(r.current)
+		c.Output = append(c.Output, strings.TrimSpace(line))
+	}
+}
+
+// finish 只保留叶子测试 (父测试的结果由子测试体现)，并补全没有结果行的测试。
+func (r *testReport) finish() {
+	r.Seconds = time.Since(r.Started).Seconds()
+	var leaves []*reportCase
+	for _, c := range r.Tests {
+		leaf := true
+		for _, o := range r.Tests {
+			if strings.HasPrefix(o.Name, c.Name+"/") {
+				leaf = false
+				break
+			}
+		}
+		if !leaf {
+			continue
+		}
+		if c.Status == "" {
+			c.Status = "fail" // 测试进程在该测试中途退出或超时
+		}
+		leaves = append(leaves, c)
+	}
+	r.Tests = leaves
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+type junitCase struct {
+	Name       string          `xml:"name,attr"`
+	Classname  string          `xml:"classname,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitMessage   `xml:"failure,omitempty"`
+	Skipped    *junitMessage   `xml:"skipped,omitempty"`
+	SystemOut  string          `xml:"system-out,omitempty"`
+}
+
+type junitSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitCase     `xml:"testcase"`
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+// junit 按顶层测试函数分组为 testsuite；classname 带上 endpoint，便于按传输层追踪历史。
+func (r *testReport) junit() junitSuites {
+	var props []junitProperty
+	props = append(props, junitProperty{"broker_version", r.BrokerVersion})
+	for _, name := range []string{"tcp", "ws", "ssl", "wss"} {
+		if url, ok := r.Endpoints[name]; ok {
+			props = append(props, junitProperty{"endpoint." + name, url})
+		}
+	}
+
+	var out junitSuites
+	index := map[string]int{}
+	var seconds []float64
+	for _, c := range r.Tests {
+		top, rest, _ := strings.Cut(c.Name, "/")
+		i, ok := index[top]
+		if !ok {
+			i = len(out.Suites)
+			index[top] = i
+			out.Suites = append(out.Suites, junitSuite{Name: top, Timestamp: r.Started.Format(time.RFC3339), Properties: props})
+			seconds = append(seconds, 0)
+		}
+		s := &out.Suites[i]
+
+		jc := junitCase{Name: c.Name, Classname: top, Time: strconv.FormatFloat(c.Seconds, 'f', 3, 64)}
+		if rest != "" {
+			jc.Name = rest
+		}
+		if c.Endpoint != "" {
+			jc.Classname = top + "." + c.Endpoint
+			jc.Properties = []junitProperty{{"endpoint", c.Endpoint}, {"url", c.URL}}
+		}
+		body := strings.Join(c.Output, "\n")
+		switch c.Status {
+		case "fail":
+			s.Failures++
+			msg := "test failed"
+			if len(c.Output) > 0 {
+				msg = c.Output[len(c.Output)-1]
+			}
+			jc.Failure = &junitMessage{Message: msg, Body: body}
+		case "skip":
+			s.Skipped++
+			jc.Skipped = &junitMessage{Message: body}
+		default:
+			jc.SystemOut = body
+		}
+		s.Tests++
+		seconds[i] += c.Seconds
+		s.Time = strconv.FormatFloat(seconds[i], 'f', 3, 64)
+		s.Cases = append(s.Cases, jc)
+	}
+	return out
+}
+
+func (r *testReport) write(base string) error {
+	x, err := xml.MarshalIndent(r.junit(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(base+".xml", append([]byte(xml.Header), append(x, '\n')...), 0o644); err != nil {
+		return err
+	}
+	j, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(base+".json", append(j, '\n'), 0o644)
+}
+
+// brokerVersion 订阅 $SYS/broker/version 读取 Broker 版本，读不到时返回 "unknown"。
+func brokerVersion(eps []brokerEndpoint) string {
+	if len(eps) == 0 {
+		return "unknown"
+	}
+	got := make(chan string, 1)
+	c := createClient(eps[0].url, "report_version_"+randSuffix(), true)
+	if tok := c.Connect(); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		return "unknown"
+	}
+	defer c.Disconnect(250)
+	c.Subscribe("$SYS/broker/version", 0, func(client mqtt.Client, msg mqtt.Message) {
+		select {
+		case got <- string(msg.Payload()):
+		default:
+		}
+	})
+	select {
+	case v := <-got:
+		return v
+	case <-time.After(time.Duration(getEnvIntOr("MQTT_REPORT_VERSION_WAIT_MS", 3000)) * time.Millisecond):
+		return "unknown"
+	}
+}
+
+// runReported 运行测试；设置了 MQTT_REPORT 时同时解析 -v 输出并写出 JUnit/JSON 报告。
+func runReported(m *testing.M) int {
+	base := getEnv("MQTT_REPORT", "")
+	if base == "" {
+		return m.Run()
+	}
+	flag.Parse()
+	if v := flag.Lookup("test.v"); v != nil && v.Value.String() == "false" {
+		_ = v.Value.Set("true")
+	}
+
+	eps := endpointsFromEnv()
+	report := newTestReport(eps)
+	report.BrokerVersion = brokerVersion(eps)
+
+	stdout := os.Stdout
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "test report disabled:", err)
+		return m.Run()
+	}
+	os.Stdout = pw
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sc := bufio.NewScanner(io.TeeReader(pr, stdout))
+		sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for sc.Scan() {
+			report.parseLine(sc.Text())
+		}
+		io.Copy(stdout, pr) // 超长行导致扫描中止时仍需排空管道
+	}()
+
+	code := m.Run()
+	os.Stdout = stdout
+	pw.Close()
+	<-done
+
+	report.finish()
+	if err := report.write(base); err != nil {
+		fmt.Fprintln(os.Stderr, "write test report:", err)
+		return 1
+	}
+	return code
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 会话过期 (MQTT 5.0 Session Expiry Interval，协议 Section 3.1.2.11.2)：断开后超过会话过期时间的
+// 持久会话连同其订阅和离线消息一起被后台清理，重连时 CONNACK 的 Session Present 为 0。
+// 3.1.1 的 CleanSession=false 会话使用 Broker 的全局默认过期时间。conf.yml 和后台尚未定义该配置项，
+// 因此由 MQTT_DEFAULT_SESSION_EXPIRY (秒) 告知测试被测 Broker 上配置的值，未设置或超过 30 秒时跳过。
+// 过期会话的 $SYS 计数和 Clients 页面展示对应的主题和接口尚不存在，不在这里测试。
+// Broker 不接受 v5 连接时整组跳过；需求阻塞在 Broker 侧，见 mqtt_pending_test.go。
+
+// sessionExpiryWait 在过期时间之外额外等待的时长，覆盖清理任务的执行间隔。
+const sessionExpiryWait = 2 * time.Second
+
+func testSessionExpiry(t *testing.T, tcp string) {
+	addr := tcpAddrFromMQTTURL(tcp)
+
+	// connect 以 CleanStart=0 连接 id，expiry 为 0 时不携带 Session Expiry Interval。
+	connect := func(t *testing.T, id string, expiry uint32) (*packetConn, *v5ConnackPacket) {
+		t.Helper()
+		p := &connectPacket{ClientID: id}
+		if expiry > 0 {
+			p.Properties = v5Properties{propSessionExpiry: expiry}
+		}
+		return mustV5RawConnect(t, addr, p)
+	}
+
+	// offline 建立订阅了 topic 的会话后断开，并在断开期间向 topic 发布一条 QoS 1 消息。
+	offline := func(t *testing.T, id, topic string, expiry uint32, disconnect *v5DisconnectPacket) {
+		t.Helper()
+		t.Cleanup(func() { rawSessionCleanup(addr, id) })
+		conn, _ := connect(t, id, expiry)
+		v5Subscribe(t, conn, 1, topic, 1)
+		_ = conn.writePacket(disconnect)
+		conn.Close()
+		confPublish(t, confClient(t, addr), &publishPacket{QoS: 1, PacketID: 1, Topic: topic, Payload: []byte("queued")})
+	}
+
+	// expectExpired 断言会话已被清理：Session Present 为 0，且离线消息未被保留下来。
+	expectExpired := func(t *testing.T, id string) {
+		t.Helper()
+		conn, ack := connect(t, id, 300)
+		if ack.SessionPresent {
+			t.Fatal("session still present after its expiry interval")
+		}
+		v5ExpectNoPublish(t, conn, time.Second)
+	}
+
+	t.Run("Expired_Session_Purged", func(t *testing.T) {
+		parallel(t)
+		id := "sexp_" + randSuffix()
+		offline(t, id, topicWithSuffix("cp7/test/sexp"), 1, &v5DisconnectPacket{})
+		time.Sleep(time.Second + sessionExpiryWait)
+		expectExpired(t, id)
+	})
+
+	t.Run("Session_Kept_Within_Interval", func(t *testing.T) {
+		parallel(t)
+		id := "sexp_keep_" + randSuffix()
+		offline(t, id, topicWithSuffix("cp7/test/sexp_keep"), 60, &v5DisconnectPacket{})
+		time.Sleep(time.Second)
+		conn, ack := connect(t, id, 60)
+		if !ack.SessionPresent {
+			t.Fatal("session expired before its interval elapsed")
+		}
+		if p := v5ExpectPublish(t, conn, 5*time.Second); string(p.Payload) != "queued" {
+			t.Fatalf("resumed session got %q, want queued", p.Payload)
+		}
+	})
+
+	t.Run("Zero_Interval_Ends_At_Disconnect", func(t *testing.T) {
+		parallel(t)
+		// 未携带 Session Expiry Interval (即 0) 时，CleanStart=0 的会话在网络连接关闭时结束
+		id := "sexp_zero_" + randSuffix()
+		offline(t, id, topicWithSuffix("cp7/test/sexp_zero"), 0, &v5DisconnectPacket{})
+		expectExpired(t, id)
+	})
+
+	t.Run("Disconnect_Updates_Interval", func(t *testing.T) {
+		parallel(t)
+		// DISCONNECT 中的 Session Expiry Interval 覆盖 CONNECT 中的值
+		id := "sexp_upd_" + randSuffix()
+		offline(t, id, topicWithSuffix("cp7/test/sexp_upd"), 300,
+			&v5DisconnectPacket{Properties: v5Properties{propSessionExpiry: 1}})
+		time.Sleep(time.Second + sessionExpiryWait)
+		expectExpired(t, id)
+	})
+
+	t.Run("Default_Expiry_V311", func(t *testing.T) {
+		parallel(t)
+		def := getEnvInt(t, "MQTT_DEFAULT_SESSION_EXPIRY", 0)
+		if def <= 0 || def > 30 {
+			t.Skipf("default session expiry is %d s: set MQTT_DEFAULT_SESSION_EXPIRY to the broker's 1-30 s default to run", def)
+		}
+		id := "sexp_v311_" + randSuffix()
+		topic := topicWithSuffix("cp7/test/sexp_v311")
+		t.Cleanup(func() { rawSessionCleanup(addr, id) })
+
+		conn := rawSessionConnect(t, addr, id, false)
+		confSubscribe(t, conn, 1, topic, 1)
+		_ = conn.writePacket(&emptyPacket{Type: pktDISCONNECT})
+		conn.Close()
+		confPublish(t, confClient(t, addr), &publishPacket{QoS: 1, PacketID: 1, Topic: topic, Payload: []byte("queued")})
+		time.Sleep(time.Duration(def)*time.Second + sessionExpiryWait)
+
+		resumed := rawSessionConnect(t, addr, id, false)
+		defer resumed.Close()
+		expectNoPublish(t, resumed, time.Second)
+	})
+}
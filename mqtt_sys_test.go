@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// $SYS 计数器正确性：观察者订阅各计数器主题，按 Broker 的发布周期取样。两次空闲取样之差是观察者
+// 自身带来的每周期开销 (收到 $SYS 消息也计入 messages/sent)；随后执行已知数量的连接、订阅和发布，
+// 并断言下一个周期的取样与基线之差恰好等于这些数量 (加上同样周期数的开销)，uptime 单调递增。
+//
+// 计数器是全局的，因此该测试作为独立的顶层测试运行 (不与并行的功能测试重叠)，并要求 Broker 上
+// 没有其他流量；基线期间计数器发生变化时跳过。发布周期由 MQTT_SYS_INTERVAL_SECONDS 指定 (默认 10)。
+
+const (
+	sysConnected     = "$SYS/broker/clients/connected"
+	sysTotal         = "$SYS/broker/clients/total"
+	sysReceived      = "$SYS/broker/messages/received"
+	sysSent          = "$SYS/broker/messages/sent"
+	sysSubscriptions = "$SYS/broker/subscriptions/count"
+	sysUptime        = "$SYS/broker/uptime"
+)
+
+var sysCounterTopics = []string{sysConnected, sysTotal, sysReceived, sysSent, sysSubscriptions, sysUptime}
+
+// sysBurstGap 用于划分发布周期：间隔超过该值的两条 $SYS 消息属于不同周期。
+const sysBurstGap = 300 * time.Millisecond
+
+type sysBurst struct {
+	start, end time.Time
+	values     map[string]int64
+}
+
+// sysCollector 把观察者收到的 $SYS 消息按发布周期分组。
+type sysCollector struct {
+	mu     sync.Mutex
+	bursts []*sysBurst
+}
+
+func (c *sysCollector) handler(client mqtt.Client, msg mqtt.Message) {
+	// uptime 等可能带单位 (如 "123 seconds")，只取第一个数字
+	fields := strings.Fields(string(msg.Payload()))
+	if len(fields) == 0 {
+		return
+	}
+	v, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := len(c.bursts); n == 0 || now.Sub(c.bursts[n-1].end) > sysBurstGap {
+		c.bursts = append(c.bursts, &sysBurst{start: now, values: map[string]int64{}})
+	}
+	b := c.bursts[len(c.bursts)-1]
+	b.end = now
+	b.values[msg.Topic()] = v
+}
+
+// next 返回在 after 之后开始、已经结束且包含全部计数器的第一个周期及其序号。
+func (c *sysCollector) next(t *testing.T, after time.Time, timeout time.Duration) (int, map[string]int64) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		for i, b := range c.bursts {
+			if !b.start.After(after) || time.Since(b.end) <= sysBurstGap || len(b.values) < len(sysCounterTopics) {
+				continue
+			}
+			c.mu.Unlock()
+			return i, b.values
+		}
+		c.mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("no complete $SYS update within %v", timeout)
+	return 0, nil
+}
+
+func TestMQTT_SysCounters(t *testing.T) {
+	tcp := tcpEndpoint(t, mustEndpoints(t))
+	interval := time.Duration(getEnvInt(t, "MQTT_SYS_INTERVAL_SECONDS", 10)) * time.Second
+	clients := getEnvInt(t, "MQTT_SYS_CLIENTS", 5)
+	messages := getEnvInt(t, "MQTT_SYS_MESSAGES", 10)
+	wait := interval + 2*time.Second
+
+	// 观察者和工作客户端使用较长的 Keep Alive，避免 PINGREQ 干扰计数
+	connect := func(id string) mqtt.Client {
+		opts := newClientOptions(tcp, id, true)
+		opts.SetKeepAlive(10 * time.Minute)
+		c := mqtt.NewClient(opts)
+		mustConnect(t, c, 5*time.Second)
+		return c
+	}
+
+	col := &sysCollector{}
+	obs := connect("sys_observer_" + randSuffix())
+	defer obs.Disconnect(250)
+	filters := make(map[string]byte, len(sysCounterTopics))
+	for _, topic := range sysCounterTopics {
+		filters[topic] = 0
+	}
+	mustWaitToken(t, obs.SubscribeMultiple(filters, col.handler), 5*time.Second, "subscribe $SYS")
+
+	// 订阅时收到的保留值可能是旧周期的，从下一个周期开始取基线
+	_, _ = col.next(t, time.Now(), wait)
+	i0, b0 := col.next(t, time.Now(), wait)
+	i1, b1 := col.next(t, time.Now(), wait)
+	for _, topic := range []string{sysConnected, sysTotal, sysSubscriptions} {
+		if b0[topic] != b1[topic] {
+			t.Skipf("broker not idle: %s changed from %d to %d between idle samples", topic, b0[topic], b1[topic])
+		}
+	}
+	perCycle := func(topic string) int64 { return (b1[topic] - b0[topic]) / int64(i1-i0) }
+
+	// 已知操作：clients 个客户端各订阅一个公共主题，第一个客户端发布 messages 条 QoS 1 消息
+	topic := topicWithSuffix("cp7/test/sys")
+	var got sync.WaitGroup
+	got.Add(clients * messages)
+	workers := make([]mqtt.Client, clients)
+	for i := range workers {
+		workers[i] = connect("sys_worker_" + randSuffix())
+		defer workers[i].Disconnect(250)
+		mustWaitToken(t, workers[i].Subscribe(topic, 1, func(client mqtt.Client, msg mqtt.Message) {
+			got.Done()
+		}), 5*time.Second, "subscribe")
+	}
+	for i := 0; i < messages; i++ {
+		mustWaitToken(t, workers[0].Publish(topic, 1, false, "sys_"+strconv.Itoa(i)), 5*time.Second, "publish")
+	}
+	if waitTimeout(&got, 10*time.Second) {
+		t.Fatal("not all messages delivered to the subscribers")
+	}
+
+	// 计数器必须在操作完成后的下一个发布周期内反映变化
+	i2, b2 := col.next(t, time.Now(), wait)
+	cycles := int64(i2 - i1)
+	want := map[string]int64{
+		sysConnected:     int64(clients),
+		sysTotal:         int64(clients),
+		sysSubscriptions: int64(clients),
+		sysReceived:      int64(messages) + perCycle(sysReceived)*cycles,
+		sysSent:          int64(clients*messages) + perCycle(sysSent)*cycles,
+	}
+	for _, topic := range []string{sysConnected, sysTotal, sysSubscriptions, sysReceived, sysSent} {
+		if d := b2[topic] - b1[topic]; d != want[topic] {
+			t.Errorf("%s moved by %d (%d -> %d), want %d", topic, d, b1[topic], b2[topic], want[topic])
+		}
+	}
+
+	for _, p := range [][2]map[string]int64{{b0, b1}, {b1, b2}} {
+		if p[1][sysUptime] <= p[0][sysUptime] {
+			t.Errorf("%s did not increase: %d -> %d", sysUptime, p[0][sysUptime], p[1][sysUptime])
+		}
+	}
+
+	// 工作客户端断开后，在线数和订阅数回到基线
+	for _, w := range workers {
+		w.Disconnect(250)
+	}
+	_, b3 := col.next(t, time.Now(), wait)
+	for _, topic := range []string{sysConnected, sysSubscriptions} {
+		if b3[topic] != b1[topic] {
+			t.Errorf("%s = %d after workers disconnected, want baseline %d", topic, b3[topic], b1[topic])
+		}
+	}
+	if b3[sysUptime] <= b2[sysUptime] {
+		t.Errorf("%s did not increase: %d -> %d", sysUptime, b2[sysUptime], b3[sysUptime])
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// TLS / WSS：TestMain 生成一次性的 CA、服务端证书和客户端证书，另起一个开启 tcp_tls_* 与 websocket_tls_*
+// 的本地 Broker，功能测试的每个 endpoint 子测试同时在 ssl:// 和 wss:// 上运行 (MQTT_TLS=0 关闭)。
+// 外部 Broker 通过 MQTT_SSL_URL / MQTT_WSS_URL 指定，信任的 CA 和客户端证书由
+// MQTT_TLS_CA / MQTT_TLS_CERT / MQTT_TLS_KEY (PEM 文件路径) 指定。
+
+// testTLSFixture 是 TestMain 生成的证书，使用外部 Broker 时为 nil。
+var testTLSFixture *tlsFixture
+
+type tlsFixture struct {
+	caPool           *x509.CertPool
+	serverPem        string // 服务端证书文件，包含 127.0.0.1 / localhost
+	serverKey        string
+	expiredServerPem string // 由受信 CA 签发、主机名匹配但已过期的服务端证书文件
+	expiredServerKey string
+	client           tls.Certificate
+	expired          tls.Certificate // 由受信 CA 签发但已过期的客户端证书
+	untrusted        tls.Certificate // 由另一个 CA 签发的客户端证书
+	otherPool        *x509.CertPool  // 不包含测试 CA 的信任池
+}
+
+type certAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCertAuthority(cn string) (*certAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          newSerial(),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &certAuthority{cert: cert, key: key}, nil
+}
+
+func newSerial() *big.Int {
+	n, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	return n
+}
+
+// issue 签发叶子证书，notAfter 早于当前时间即得到过期证书。
+func (ca *certAuthority) issue(cn string, usage x509.ExtKeyUsage, notAfter time.Time) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: newSerial(),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    notAfter.Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	if usage == x509.ExtKeyUsageServerAuth {
+		tmpl.DNSNames = []string{"localhost"}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1"), net.IPv6loopback}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// writeCertPair 把证书和私钥以 PEM 写入 dir，返回文件路径。
+func writeCertPair(dir, name string, c tls.Certificate) (pemPath, keyPath string, err error) {
+	keyDER, err := x509.MarshalECPrivateKey(c.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		return "", "", err
+	}
+	pemPath = filepath.Join(dir, name+".pem")
+	keyPath = filepath.Join(dir, name+".key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Certificate[0]})
+	if err := os.WriteFile(pemPath, certPEM, 0o644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return "", "", err
+	}
+	return pemPath, keyPath, nil
+}
+
+// newTLSFixture 在 dir 中生成测试 CA、服务端/客户端证书以及负向用例使用的证书。
+func newTLSFixture(dir string) (*tlsFixture, error) {
+	ca, err := newCertAuthority("AXMQ Test CA")
+	if err != nil {
+		return nil, err
+	}
+	other, err := newCertAuthority("AXMQ Untrusted CA")
+	if err != nil {
+		return nil, err
+	}
+	valid := time.Now().Add(24 * time.Hour)
+	f := &tlsFixture{caPool: x509.NewCertPool(), otherPool: x509.NewCertPool()}
+	f.caPool.AddCert(ca.cert)
+	f.otherPool.AddCert(other.cert)
+
+	server, err := ca.issue("localhost", x509.ExtKeyUsageServerAuth, valid)
+	if err != nil {
+		return nil, err
+	}
+	if f.serverPem, f.serverKey, err = writeCertPair(dir, "server", server); err != nil {
+		return nil, err
+	}
+	expiredServer, err := ca.issue("localhost", x509.ExtKeyUsageServerAuth, time.Now().Add(-time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	if f.expiredServerPem, f.expiredServerKey, err = writeCertPair(dir, "server_expired", expiredServer); err != nil {
+		return nil, err
+	}
+	if f.client, err = ca.issue("axmq-test-client", x509.ExtKeyUsageClientAuth, valid); err != nil {
+		return nil, err
+	}
+	if f.expired, err = ca.issue("axmq-expired-client", x509.ExtKeyUsageClientAuth, time.Now().Add(-time.Hour)); err != nil {
+		return nil, err
+	}
+	if f.untrusted, err = other.issue("axmq-untrusted-client", x509.ExtKeyUsageClientAuth, valid); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// tlsClientConfig 返回 ssl:// 与 wss:// 连接使用的 TLS 配置：优先使用 MQTT_TLS_* 指定的文件，
+// 其次是 TestMain 生成的测试证书。
+func tlsClientConfig() *tls.Config {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca := getEnv("MQTT_TLS_CA", ""); ca != "" {
+		if data, err := os.ReadFile(ca); err == nil {
+			cfg.RootCAs = x509.NewCertPool()
+			cfg.RootCAs.AppendCertsFromPEM(data)
+		}
+	} else if testTLSFixture != nil {
+		cfg.RootCAs = testTLSFixture.caPool
+	}
+	if certFile, keyFile := getEnv("MQTT_TLS_CERT", ""), getEnv("MQTT_TLS_KEY", ""); certFile != "" && keyFile != "" {
+		if c, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+			cfg.Certificates = []tls.Certificate{c}
+		}
+	} else if testTLSFixture != nil {
+		cfg.Certificates = []tls.Certificate{testTLSFixture.client}
+	}
+	return cfg
+}
+
+// isTLSURL 判断 Broker 地址是否需要 TLS。
+func isTLSURL(u string) bool {
+	for _, p := range []string{"ssl://", "tls://", "mqtts://", "tcps://", "wss://"} {
+		if strings.HasPrefix(u, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// startTLSBroker 在 dir 中生成证书并拉起只监听 TLS (TCP + WebSocket) 的本地 Broker。
+func startTLSBroker(bin, dir string) (*brokerProcess, *tlsFixture, error) {
+	f, err := newTLSFixture(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate certificates: %w", err)
+	}
+	cfg, err := newLocalBrokerConfig(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.TCPTLSPem, cfg.TCPTLSKey = f.serverPem, f.serverKey
+	cfg.WebsocketTLSPem, cfg.WebsocketTLSKey = f.serverPem, f.serverKey
+	b, err := startBroker(bin, dir, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, f, nil
+}
+
+// startTestBroker 为单个用例拉起一个额外的本地 Broker，configure 在默认配置 (随机端口) 上设置证书，
+// 用例结束时关闭。找不到 Broker 二进制或 Broker 未能启动时返回错误。
+func startTestBroker(t *testing.T, configure func(*brokerConfig)) ([]brokerEndpoint, error) {
+	t.Helper()
+	bin := findBrokerBinary()
+	if bin == "" {
+		return nil, errors.New("ApexMQTT binary not found")
+	}
+	dir := t.TempDir()
+	cfg, err := newLocalBrokerConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	configure(&cfg)
+	b, err := startBroker(bin, dir, cfg)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(b.stop)
+	return b.endpoints(), nil
+}
+
+// startExpiredServerBroker 拉起一个使用过期服务端证书的 Broker，返回按名称 (ssl / wss) 索引的 endpoint。
+func startExpiredServerBroker(t *testing.T, f *tlsFixture) map[string]brokerEndpoint {
+	t.Helper()
+	eps, err := startTestBroker(t, func(cfg *brokerConfig) {
+		cfg.TCPTLSPem, cfg.TCPTLSKey = f.expiredServerPem, f.expiredServerKey
+		cfg.WebsocketTLSPem, cfg.WebsocketTLSKey = f.expiredServerPem, f.expiredServerKey
+	})
+	if err != nil {
+		t.Skipf("broker with an expired server certificate unavailable: %v", err)
+	}
+	byName := map[string]brokerEndpoint{}
+	for _, ep := range eps {
+		byName[ep.name] = ep
+	}
+	return byName
+}
+
+// tlsEndpoints 返回 eps 中使用 TLS 的 endpoint。
+func tlsEndpoints(eps []brokerEndpoint) []brokerEndpoint {
+	var out []brokerEndpoint
+	for _, ep := range eps {
+		if isTLSURL(ep.url) {
+			out = append(out, ep)
+		}
+	}
+	return out
+}
+
+// tlsConnect 用指定的 TLS 配置建立 MQTT 连接，返回握手时 Broker 是否请求了客户端证书以及连接错误。
+func tlsConnect(ep brokerEndpoint, cfg *tls.Config) (clientCertRequested bool, err error) {
+	var requested atomic.Bool
+	if len(cfg.Certificates) > 0 {
+		certs := cfg.Certificates
+		cfg.Certificates = nil
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			requested.Store(true)
+			return &certs[0], nil
+		}
+	}
+	opts := newClientOptions(ep.url, ep.name+"_tlsneg_"+randSuffix(), true)
+	opts.SetTLSConfig(cfg)
+	c := mqtt.NewClient(opts)
+	tok := c.Connect()
+	if !tok.WaitTimeout(10 * time.Second) {
+		return requested.Load(), errors.New("connect timeout")
+	}
+	if tok.Error() == nil {
+		// TLS 1.3 下服务端对客户端证书的拒绝在握手完成后才到达，做一次 SUBSCRIBE 往返确认连接仍然可用
+		sub := c.Subscribe(topicWithSuffix("cp7/test/tls"), 1, nil)
+		if !sub.WaitTimeout(3*time.Second) || sub.Error() != nil || !c.IsConnectionOpen() {
+			return requested.Load(), errors.New("connection closed after handshake")
+		}
+		c.Disconnect(250)
+	}
+	return requested.Load(), tok.Error()
+}
+
+func TestMQTT_TLS(t *testing.T) {
+	eps := tlsEndpoints(mustEndpoints(t))
+	if len(eps) == 0 {
+		t.Skip("no TLS endpoint: local TLS broker disabled (MQTT_TLS=0) or MQTT_SSL_URL/MQTT_WSS_URL not set")
+	}
+	f := testTLSFixture
+	if f == nil {
+		t.Skip("negative TLS cases need the generated test certificates (local broker only)")
+	}
+	for _, ep := range eps {
+		ep := ep
+		t.Run(ep.name, func(t *testing.T) {
+			t.Run("Trusted_Client", func(t *testing.T) {
+				if _, err := tlsConnect(ep, tlsClientConfig()); err != nil {
+					t.Fatalf("connect with test CA and client certificate: %v", err)
+				}
+			})
+
+			t.Run("Untrusted_CA", func(t *testing.T) {
+				// 客户端只信任另一个 CA，握手必须失败
+				cfg := tlsClientConfig()
+				cfg.RootCAs = f.otherPool
+				if _, err := tlsConnect(ep, cfg); err == nil {
+					t.Fatal("connected although the server certificate is not signed by a trusted CA")
+				}
+			})
+
+			t.Run("Wrong_SNI", func(t *testing.T) {
+				// 服务端证书只包含 localhost / 127.0.0.1，使用其它 SNI 时校验必须失败
+				cfg := tlsClientConfig()
+				cfg.ServerName = "wrong-host.invalid"
+				if _, err := tlsConnect(ep, cfg); err == nil {
+					t.Fatal("connected with SNI that does not match the server certificate")
+				}
+			})
+
+			t.Run("Expired_Client_Certificate", func(t *testing.T) {
+				cfg := tlsClientConfig()
+				cfg.Certificates = []tls.Certificate{f.expired}
+				requested, err := tlsConnect(ep, cfg)
+				if !requested {
+					t.Skip("broker does not request client certificates")
+				}
+				if err == nil {
+					t.Fatal("broker accepted an expired client certificate")
+				}
+			})
+
+			t.Run("Untrusted_Client_Certificate", func(t *testing.T) {
+				cfg := tlsClientConfig()
+				cfg.Certificates = []tls.Certificate{f.untrusted}
+				requested, err := tlsConnect(ep, cfg)
+				if !requested {
+					t.Skip("broker does not request client certificates")
+				}
+				if err == nil {
+					t.Fatal("broker accepted a client certificate from an untrusted CA")
+				}
+			})
+		})
+	}
+
+	// 过期服务端证书需要单独的 Broker，启动失败时只跳过这一组
+	t.Run("Expired_Server_Certificate", func(t *testing.T) {
+		expiredServer := startExpiredServerBroker(t, f)
+		for _, ep := range eps {
+			ep := ep
+			t.Run(ep.name, func(t *testing.T) {
+				// 证书由受信 CA 签发且主机名匹配，唯一的问题是已过期，客户端校验必须失败
+				_, err := tlsConnect(expiredServer[ep.name], tlsClientConfig())
+				if err == nil {
+					t.Fatal("connected although the server certificate has expired")
+				}
+				// paho 不保留错误链，按 crypto/x509 的错误信息确认失败原因是过期
+				if !strings.Contains(err.Error(), "certificate has expired") {
+					t.Fatalf("handshake failed for a reason other than expiry: %v", err)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// MQTT 5.0 主题别名 (协议 Section 3.3.2.3.4)：
+//   - 入站：客户端可使用不超过 CONNACK 中 Topic Alias Maximum 的别名，别名 0 或超出上限时 Broker
+//     以 DISCONNECT 0x94 断开，使用未建立映射的别名时以 0x82 断开；
+//   - 出站：Broker 只能使用不超过客户端 CONNECT 中 Topic Alias Maximum 的别名，客户端未声明时不得使用别名；
+//   - 别名表属于单个网络连接，重连后失效，扇出时各订阅者的别名互不影响。
+// 测试使用带长层级的主题，贴近蜂窝设备按字节计费的场景。
+// Broker 不接受 v5 连接时整组跳过，入站用例另外要求 CONNACK 携带 Topic Alias Maximum；
+// 需求阻塞在 Broker 侧，见 mqtt_pending_test.go。
+
+// aliasTable 模拟客户端侧的入站别名表，按协议解析收到的 PUBLISH 的主题。
+type aliasTable struct {
+	limit  uint32
+	topics map[uint32]string
+	reused int // 以空主题 + 别名送达的消息数
+}
+
+func newAliasTable(limit uint32) *aliasTable {
+	return &aliasTable{limit: limit, topics: map[uint32]string{}}
+}
+
+func (a *aliasTable) resolve(p *v5PublishPacket) (string, error) {
+	alias, ok := p.Properties[propTopicAlias]
+	if !ok {
+		if p.Topic == "" {
+			return "", fmt.Errorf("PUBLISH without topic or topic alias")
+		}
+		return p.Topic, nil
+	}
+	if alias == 0 || alias > a.limit {
+		return "", fmt.Errorf("topic alias %d outside client maximum %d", alias, a.limit)
+	}
+	if p.Topic != "" {
+		a.topics[alias] = p.Topic
+		return p.Topic, nil
+	}
+	topic, ok := a.topics[alias]
+	if !ok {
+		return "", fmt.Errorf("topic alias %d used before it was set", alias)
+	}
+	a.reused++
+	return topic, nil
+}
+
+// expectAliased 读取下一条 PUBLISH，经别名表解析后断言主题和载荷。
+func (a *aliasTable) expectAliased(t *testing.T, conn *packetConn, topic, payload string) {
+	t.Helper()
+	p := v5ExpectPublish(t, conn, 5*time.Second)
+	got, err := a.resolve(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != topic || string(p.Payload) != payload {
+		t.Fatalf("got %s %q, want %s %q", got, p.Payload, topic, payload)
+	}
+}
+
+// aliasTopic 返回带长层级的测试主题。
+func aliasTopic(name string) string {
+	return topicWithSuffix("cp7/test/alias/" + name + "/site-0042/gateway-cellular-7/telemetry/sensor")
+}
+
+func testTopicAlias(t *testing.T, tcp string) {
+	addr := tcpAddrFromMQTTURL(tcp)
+
+	// inbound 连接一个 v5 发布者，返回连接和 Broker 允许的入站别名上限 (为 0 时跳过)
+	inbound := func(t *testing.T) (*packetConn, uint32) {
+		t.Helper()
+		conn, ack := mustV5RawConnect(t, addr, &connectPacket{CleanSession: true, ClientID: "alias_pub_" + randSuffix()})
+		limit := ack.Properties[propTopicAliasMaximum]
+		if limit == 0 {
+			t.Skip("broker does not advertise Topic Alias Maximum in CONNACK")
+		}
+		return conn, limit
+	}
+
+	t.Run("Inbound_Set_And_Reuse", func(t *testing.T) {
+		parallel(t)
+		pub, _ := inbound(t)
+		a, b := aliasTopic("in_a"), aliasTopic("in_b")
+		sub := confClient(t, addr)
+		confSubscribe(t, sub, 1, a, 1)
+		confSubscribe(t, sub, 2, b, 1)
+
+		steps := []struct {
+			topic, want, payload string
+		}{
+			{a, a, "set"},    // 建立别名 1 -> a
+			{"", a, "reuse"}, // 仅携带别名
+			{b, b, "remap"},  // 重新映射别名 1 -> b
+			{"", b, "reuse_remapped"},
+		}
+		for i, s := range steps {
+			id := uint16(i + 1)
+			if rc := v5Publish(t, pub, &v5PublishPacket{QoS: 1, PacketID: id, Topic: s.topic,
+				Properties: v5Properties{propTopicAlias: 1}, Payload: []byte(s.payload)}); rc != rcSuccess {
+				t.Fatalf("%s: PUBACK reason %#x, want 0x00", s.payload, rc)
+			}
+			p := confExpectPublish(t, sub)
+			if p.Topic != s.want || string(p.Payload) != s.payload {
+				t.Fatalf("%s: subscriber got %s %q, want %s", s.payload, p.Topic, p.Payload, s.want)
+			}
+		}
+	})
+
+	t.Run("Inbound_Alias_Zero", func(t *testing.T) {
+		parallel(t)
+		pub, _ := inbound(t)
+		_ = pub.writePacket(&v5PublishPacket{Topic: aliasTopic("zero"), Properties: v5Properties{propTopicAlias: 0}, Payload: []byte("x")})
+		v5ExpectDisconnect(t, pub, rcTopicAliasInvalid)
+	})
+
+	t.Run("Inbound_Alias_Above_Maximum", func(t *testing.T) {
+		parallel(t)
+		pub, limit := inbound(t)
+		if limit == 0xFFFF {
+			t.Skip("Topic Alias Maximum is 65535, no out-of-range alias exists")
+		}
+		_ = pub.writePacket(&v5PublishPacket{Topic: aliasTopic("over"), Properties: v5Properties{propTopicAlias: limit + 1}, Payload: []byte("x")})
+		v5ExpectDisconnect(t, pub, rcTopicAliasInvalid)
+	})
+
+	t.Run("Inbound_Unmapped_Alias", func(t *testing.T) {
+		parallel(t)
+		pub, _ := inbound(t)
+		_ = pub.writePacket(&v5PublishPacket{Properties: v5Properties{propTopicAlias: 1}, Payload: []byte("x")})
+		v5ExpectDisconnect(t, pub, rcProtocolError)
+	})
+
+	t.Run("Inbound_Aliases_Reset_On_Reconnect", func(t *testing.T) {
+		parallel(t)
+		// 即使会话被恢复，别名映射也不跨连接保留
+		id := "alias_resume_" + randSuffix()
+		t.Cleanup(func() { rawSessionCleanup(addr, id) })
+		connect := func() *packetConn {
+			conn, ack := mustV5RawConnect(t, addr, &connectPacket{ClientID: id, Properties: v5Properties{propSessionExpiry: 300}})
+			if ack.Properties[propTopicAliasMaximum] == 0 {
+				t.Skip("broker does not advertise Topic Alias Maximum in CONNACK")
+			}
+			return conn
+		}
+		first := connect()
+		v5Publish(t, first, &v5PublishPacket{QoS: 1, PacketID: 1, Topic: aliasTopic("resume"),
+			Properties: v5Properties{propTopicAlias: 1}, Payload: []byte("set")})
+		_ = first.writePacket(&v5DisconnectPacket{})
+		first.Close()
+
+		second := connect()
+		_ = second.writePacket(&v5PublishPacket{Properties: v5Properties{propTopicAlias: 1}, Payload: []byte("stale")})
+		v5ExpectDisconnect(t, second, rcProtocolError)
+	})
+
+	// outbound 连接一个声明了 limit 个出站别名的 v5 订阅者 (limit 为 0 时不携带该属性)
+	outbound := func(t *testing.T, limit uint32, filter string) (*packetConn, *aliasTable) {
+		t.Helper()
+		p := &connectPacket{CleanSession: true, ClientID: "alias_sub_" + randSuffix()}
+		if limit > 0 {
+			p.Properties = v5Properties{propTopicAliasMaximum: limit}
+		}
+		conn, _ := mustV5RawConnect(t, addr, p)
+		if rc := v5Subscribe(t, conn, 1, filter, 1); rc != 1 {
+			t.Fatalf("SUBACK reason %#x, want granted QoS 1", rc)
+		}
+		return conn, newAliasTable(limit)
+	}
+
+	t.Run("Outbound_Alias_Reused", func(t *testing.T) {
+		parallel(t)
+		topic := aliasTopic("out")
+		sub, table := outbound(t, 4, topic)
+		pub := confClient(t, addr)
+		const n = 5
+		for i := 0; i < n; i++ {
+			payload := fmt.Sprintf("m%d", i)
+			confPublish(t, pub, &publishPacket{QoS: 1, PacketID: uint16(i + 1), Topic: topic, Payload: []byte(payload)})
+			table.expectAliased(t, sub, topic, payload)
+		}
+		if table.reused == 0 {
+			t.Fatalf("none of %d messages on a repeated topic used an outbound topic alias", n)
+		}
+	})
+
+	t.Run("Outbound_Maximum_Respected", func(t *testing.T) {
+		parallel(t)
+		// 主题数多于客户端允许的别名数，Broker 必须复用或不用别名，不能超出上限
+		base := aliasTopic("outmax")
+		sub, table := outbound(t, 2, base+"/#")
+		pub := confClient(t, addr)
+		id := uint16(0)
+		for round := 0; round < 3; round++ {
+			for i := 0; i < 4; i++ {
+				id++
+				topic := fmt.Sprintf("%s/t%d", base, i)
+				payload := fmt.Sprintf("r%d_t%d", round, i)
+				confPublish(t, pub, &publishPacket{QoS: 1, PacketID: id, Topic: topic, Payload: []byte(payload)})
+				table.expectAliased(t, sub, topic, payload)
+			}
+		}
+	})
+
+	t.Run("Outbound_No_Alias_Without_Maximum", func(t *testing.T) {
+		parallel(t)
+		topic := aliasTopic("outnone")
+		sub, table := outbound(t, 0, topic)
+		pub := confClient(t, addr)
+		for i := 0; i < 3; i++ {
+			payload := fmt.Sprintf("m%d", i)
+			confPublish(t, pub, &publishPacket{QoS: 1, PacketID: uint16(i + 1), Topic: topic, Payload: []byte(payload)})
+			table.expectAliased(t, sub, topic, payload)
+		}
+	})
+
+	t.Run("Fanout_Per_Connection_Tables", func(t *testing.T) {
+		parallel(t)
+		// 同一条消息扇出给别名上限不同的订阅者 (含 3.1.1 订阅者)，每个连接独立维护别名
+		base := aliasTopic("fanout")
+		type subscriber struct {
+			conn  *packetConn
+			table *aliasTable
+		}
+		var subs []subscriber
+		for _, limit := range []uint32{0, 1, 3} {
+			conn, table := outbound(t, limit, base+"/#")
+			subs = append(subs, subscriber{conn, table})
+		}
+		v311 := confClient(t, addr)
+		confSubscribe(t, v311, 1, base+"/#", 1)
+
+		pub, _ := mustV5RawConnect(t, addr, &connectPacket{CleanSession: true, ClientID: "alias_fanpub_" + randSuffix()})
+		id := uint16(0)
+		for round := 0; round < 2; round++ {
+			for _, leaf := range []string{"a", "b"} {
+				id++
+				topic := base + "/" + leaf
+				payload := fmt.Sprintf("r%d_%s", round, leaf)
+				v5Publish(t, pub, &v5PublishPacket{QoS: 1, PacketID: id, Topic: topic, Payload: []byte(payload)})
+				for _, s := range subs {
+					s.table.expectAliased(t, s.conn, topic, payload)
+				}
+				if p := confExpectPublish(t, v311); p.Topic != topic || string(p.Payload) != payload {
+					t.Fatalf("3.1.1 subscriber got %s %q, want %s %q", p.Topic, p.Payload, topic, payload)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,633 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"testing"
+	"time"
+)
+
+// MQTT 5.0 原生报文：在 3.1.1 编解码器 (mqtt_packet_test.go) 的基础上加入属性和原因码，
+// 用于需要逐字节控制的 v5 用例 (主题别名、消息过期、会话过期)。paho.golang 会替调用方处理
+// 这些细节，因此无法用来验证 Broker 在边界值上的行为。
+
+// MQTT 5.0 属性标识符 (协议 Section 2.2.2.2)
+const (
+	propPayloadFormat        byte = 0x01
+	propMessageExpiry        byte = 0x02
+	propContentType          byte = 0x03
+	propResponseTopic        byte = 0x08
+	propCorrelationData      byte = 0x09
+	propSubscriptionID       byte = 0x0B
+	propSessionExpiry        byte = 0x11
+	propAssignedClientID     byte = 0x12
+	propServerKeepAlive      byte = 0x13
+	propAuthMethod           byte = 0x15
+	propAuthData             byte = 0x16
+	propRequestProblemInfo   byte = 0x17
+	propWillDelay            byte = 0x18
+	propRequestResponseInfo  byte = 0x19
+	propResponseInfo         byte = 0x1A
+	propServerReference      byte = 0x1C
+	propReasonString         byte = 0x1F
+	propReceiveMaximum       byte = 0x21
+	propTopicAliasMaximum    byte = 0x22
+	propTopicAlias           byte = 0x23
+	propMaximumQoS           byte = 0x24
+	propRetainAvailable      byte = 0x25
+	propUserProperty         byte = 0x26
+	propMaximumPacketSize    byte = 0x27
+	propWildcardSubAvailable byte = 0x28
+	propSubIDAvailable       byte = 0x29
+	propSharedSubAvailable   byte = 0x2A
+)
+
+// MQTT 5.0 原因码 (协议 Section 2.4)，仅列出测试断言用到的
+const (
+	rcSuccess               byte = 0x00
+	rcNormalDisconnection   byte = 0x00
+	rcDisconnectWithWill    byte = 0x04
+	rcNoMatchingSubscribers byte = 0x10
+	rcNoSubscriptionExisted byte = 0x11
+	rcUnspecifiedError      byte = 0x80
+	rcProtocolError         byte = 0x82
+	rcUnsupportedProtocol   byte = 0x84
+	rcSessionTakenOver      byte = 0x8E
+	rcTopicFilterInvalid    byte = 0x8F
+	rcTopicAliasInvalid     byte = 0x94
+)
+
+// v5Properties 按标识符保存整数类属性 (Byte / Two Byte / Four Byte Integer / Variable Byte Integer)。
+// 解码时字符串、二进制和用户属性只校验格式后跳过。
+type v5Properties map[byte]uint32
+
+// v5PropertyWidth 返回属性值的编码宽度：1/2/4 字节整数，0 为变长整数，-1 为字符串或二进制，-2 为字符串对。
+func v5PropertyWidth(id byte) (int, bool) {
+	switch id {
+	case propPayloadFormat, propRequestProblemInfo, propRequestResponseInfo, propMaximumQoS,
+		propRetainAvailable, propWildcardSubAvailable, propSubIDAvailable, propSharedSubAvailable:
+		return 1, true
+	case propServerKeepAlive, propReceiveMaximum, propTopicAliasMaximum, propTopicAlias:
+		return 2, true
+	case propMessageExpiry, propSessionExpiry, propWillDelay, propMaximumPacketSize:
+		return 4, true
+	case propSubscriptionID:
+		return 0, true
+	case propContentType, propResponseTopic, propCorrelationData, propAssignedClientID, propAuthMethod,
+		propAuthData, propResponseInfo, propServerReference, propReasonString:
+		return -1, true
+	case propUserProperty:
+		return -2, true
+	}
+	return 0, false
+}
+
+// has 判断属性是否存在 (nil map 同样可用)。
+func (p v5Properties) has(id byte) bool {
+	_, ok := p[id]
+	return ok
+}
+
+// append 按标识符升序编码属性长度和属性，保证同一属性集的编码确定。
+func (p v5Properties) append(b []byte) []byte {
+	ids := make([]int, 0, len(p))
+	for id := range p {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	var props []byte
+	for _, id := range ids {
+		v := p[byte(id)]
+		props = append(props, byte(id))
+		switch w, _ := v5PropertyWidth(byte(id)); w {
+		case 1:
+			props = append(props, byte(v))
+		case 2:
+			props = binary.BigEndian.AppendUint16(props, uint16(v))
+		case 4:
+			props = binary.BigEndian.AppendUint32(props, v)
+		default:
+			props = appendRemainingLength(props, int(v))
+		}
+	}
+	b = appendRemainingLength(b, len(props))
+	return append(b, props...)
+}
+
+// properties 读取属性长度和属性。
+func (r *bodyReader) properties() v5Properties {
+	n := r.varint()
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < n {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	pr := &bodyReader{b: r.b[:n]}
+	r.b = r.b[n:]
+	props := v5Properties{}
+	for pr.err == nil && len(pr.b) > 0 {
+		id := pr.byte()
+		w, ok := v5PropertyWidth(id)
+		if !ok {
+			r.err = fmt.Errorf("unknown property %#x", id)
+			return nil
+		}
+		switch w {
+		case 1:
+			props[id] = uint32(pr.byte())
+		case 2:
+			props[id] = uint32(pr.uint16())
+		case 4:
+			props[id] = pr.uint32()
+		case 0:
+			props[id] = uint32(pr.varint())
+		case -1:
+			pr.binary()
+		case -2:
+			pr.binary()
+			pr.binary()
+		}
+	}
+	if pr.err != nil {
+		r.err = pr.err
+	}
+	return props
+}
+
+func (r *bodyReader) uint32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.b) < 4 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *bodyReader) varint() int {
+	if r.err != nil {
+		return 0
+	}
+	n, err := readRemainingLength(r)
+	if err != nil {
+		r.err = err
+	}
+	return n
+}
+
+// ReadByte 让 bodyReader 满足 io.ByteReader，用于读取变长整数。
+func (r *bodyReader) ReadByte() (byte, error) {
+	if len(r.b) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v, nil
+}
+
+type v5ConnackPacket struct {
+	SessionPresent bool
+	ReasonCode     byte
+	Properties     v5Properties
+}
+
+func (p *v5ConnackPacket) packetType() byte { return pktCONNACK }
+func (p *v5ConnackPacket) flags() byte      { return 0 }
+func (p *v5ConnackPacket) body() []byte {
+	return p.Properties.append([]byte{boolBit(p.SessionPresent, 0x01), p.ReasonCode})
+}
+
+type v5PublishPacket struct {
+	Dup        bool
+	QoS        byte
+	Retain     bool
+	Topic      string
+	PacketID   uint16
+	Properties v5Properties
+	Payload    []byte
+}
+
+func (p *v5PublishPacket) packetType() byte { return pktPUBLISH }
+func (p *v5PublishPacket) flags() byte {
+	return boolBit(p.Dup, 0x08) | (p.QoS&0x03)<<1 | boolBit(p.Retain, 0x01)
+}
+func (p *v5PublishPacket) body() []byte {
+	b := appendString(nil, p.Topic)
+	if p.QoS > 0 {
+		b = binary.BigEndian.AppendUint16(b, p.PacketID)
+	}
+	b = p.Properties.append(b)
+	return append(b, p.Payload...)
+}
+
+// v5AckPacket 覆盖 PUBACK/PUBREC/PUBREL/PUBCOMP；原因码为 0 且没有属性时按协议省略。
+type v5AckPacket struct {
+	Type       byte
+	PacketID   uint16
+	ReasonCode byte
+	Properties v5Properties
+}
+
+func (p *v5AckPacket) packetType() byte { return p.Type }
+func (p *v5AckPacket) flags() byte {
+	if p.Type == pktPUBREL {
+		return 0x02
+	}
+	return 0
+}
+func (p *v5AckPacket) body() []byte {
+	b := binary.BigEndian.AppendUint16(nil, p.PacketID)
+	if p.ReasonCode == rcSuccess && len(p.Properties) == 0 {
+		return b
+	}
+	return p.Properties.append(append(b, p.ReasonCode))
+}
+
+type v5SubscribePacket struct {
+	PacketID   uint16
+	Properties v5Properties
+	Topics     []subscription // QoS 字段作为完整的订阅选项字节编码
+}
+
+func (p *v5SubscribePacket) packetType() byte { return pktSUBSCRIBE }
+func (p *v5SubscribePacket) flags() byte      { return 0x02 }
+func (p *v5SubscribePacket) body() []byte {
+	b := p.Properties.append(binary.BigEndian.AppendUint16(nil, p.PacketID))
+	for _, s := range p.Topics {
+		b = appendString(b, s.Filter)
+		b = append(b, s.QoS)
+	}
+	return b
+}
+
+// v5SubackPacket 覆盖 SUBACK 和 UNSUBACK，每个过滤器一个原因码。
+type v5SubackPacket struct {
+	Type       byte
+	PacketID   uint16
+	Properties v5Properties
+	Reasons    []byte
+}
+
+func (p *v5SubackPacket) packetType() byte { return p.Type }
+func (p *v5SubackPacket) flags() byte      { return 0 }
+func (p *v5SubackPacket) body() []byte {
+	b := p.Properties.append(binary.BigEndian.AppendUint16(nil, p.PacketID))
+	return append(b, p.Reasons...)
+}
+
+// v5DisconnectPacket 的原因码为 0 且没有属性时报文体为空。
+type v5DisconnectPacket struct {
+	ReasonCode byte
+	Properties v5Properties
+}
+
+func (p *v5DisconnectPacket) packetType() byte { return pktDISCONNECT }
+func (p *v5DisconnectPacket) flags() byte      { return 0 }
+func (p *v5DisconnectPacket) body() []byte {
+	if p.ReasonCode == rcNormalDisconnection && len(p.Properties) == 0 {
+		return nil
+	}
+	return p.Properties.append([]byte{p.ReasonCode})
+}
+
+// decodeV5Packet 解析 Broker 发往 v5 客户端的报文。
+func decodeV5Packet(header byte, body []byte) (mqttPacket, error) {
+	typ, fl := header>>4, header&0x0F
+	want := byte(0)
+	switch typ {
+	case pktPUBLISH:
+		want = fl
+	case pktPUBREL:
+		want = 0x02
+	}
+	if fl != want {
+		return nil, fmt.Errorf("mqtt: invalid flags %#x for %s", fl, packetName(typ))
+	}
+
+	r := &bodyReader{b: body}
+	var p mqttPacket
+	switch typ {
+	case pktCONNACK:
+		c := &v5ConnackPacket{SessionPresent: r.byte()&0x01 != 0, ReasonCode: r.byte()}
+		c.Properties = r.properties()
+		p = c
+	case pktPUBLISH:
+		c := &v5PublishPacket{Dup: fl&0x08 != 0, QoS: (fl >> 1) & 0x03, Retain: fl&0x01 != 0}
+		if c.QoS == 3 {
+			return nil, errors.New("mqtt: PUBLISH with QoS 3")
+		}
+		c.Topic = r.string()
+		if c.QoS > 0 {
+			c.PacketID = r.uint16()
+		}
+		c.Properties = r.properties()
+		c.Payload = r.rest()
+		p = c
+	case pktPUBACK, pktPUBREC, pktPUBREL, pktPUBCOMP:
+		c := &v5AckPacket{Type: typ, PacketID: r.uint16()}
+		if len(r.b) > 0 {
+			c.ReasonCode = r.byte()
+		}
+		if len(r.b) > 0 {
+			c.Properties = r.properties()
+		}
+		p = c
+	case pktSUBACK, pktUNSUBACK:
+		c := &v5SubackPacket{Type: typ, PacketID: r.uint16()}
+		c.Properties = r.properties()
+		c.Reasons = r.rest()
+		p = c
+	case pktDISCONNECT:
+		c := &v5DisconnectPacket{}
+		if len(r.b) > 0 {
+			c.ReasonCode = r.byte()
+		}
+		if len(r.b) > 0 {
+			c.Properties = r.properties()
+		}
+		p = c
+	case pktPINGRESP:
+		p = &emptyPacket{Type: typ}
+	default:
+		return nil, fmt.Errorf("mqtt: unexpected %s from server", packetName(typ))
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("mqtt: decode v5 %s: %w", packetName(typ), r.err)
+	}
+	if len(r.b) > 0 {
+		return nil, fmt.Errorf("mqtt: %d trailing bytes in v5 %s", len(r.b), packetName(typ))
+	}
+	return p, nil
+}
+
+func (c *packetConn) readV5Packet(timeout time.Duration) (mqttPacket, error) {
+	header, body, err := c.readFrame(timeout)
+	if err != nil {
+		return nil, err
+	}
+	return decodeV5Packet(header, body)
+}
+
+// expectV5Packet 读取下一个 v5 报文并断言其类型。
+func expectV5Packet[T mqttPacket](c *packetConn, timeout time.Duration) (T, error) {
+	var zero T
+	p, err := c.readV5Packet(timeout)
+	if err != nil {
+		return zero, err
+	}
+	v, ok := p.(T)
+	if !ok {
+		if d, isDisconnect := p.(*v5DisconnectPacket); isDisconnect {
+			return zero, fmt.Errorf("expected %T, got DISCONNECT with reason %#x", zero, d.ReasonCode)
+		}
+		return zero, fmt.Errorf("expected %T, got %s", zero, packetName(p.packetType()))
+	}
+	return v, nil
+}
+
+// errV5Unsupported 表示 Broker 以 3.1.1 格式或 0x84 拒绝了协议级别 5。
+var errV5Unsupported = errors.New("broker does not accept protocol level 5")
+
+// v5RawConnect 以协议级别 5 建立原生连接并完成 CONNECT/CONNACK 握手，自动附加测试凭据。
+// p.Properties 中设置了 Session Expiry Interval 或 CleanSession=false 时登记为持久会话。
+func v5RawConnect(addr string, p *connectPacket, timeout time.Duration) (*packetConn, *v5ConnackPacket, error) {
+	p.ProtocolLevel = 5
+	applyCredentials(p)
+	conn, err := tcpDial(addr, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.ClientID != "" && (!p.CleanSession || p.Properties[propSessionExpiry] > 0) {
+		persistentSessions.Store(p.ClientID, "tcp://"+addr)
+	}
+	if err := conn.writePacket(p); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	header, body, err := conn.readFrame(timeout)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if header == pktCONNACK<<4 && len(body) == 2 && body[1] != 0 {
+		conn.Close()
+		return nil, nil, fmt.Errorf("%w: 3.1.1 CONNACK return code %#x", errV5Unsupported, body[1])
+	}
+	p2, err := decodeV5Packet(header, body)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	ack, ok := p2.(*v5ConnackPacket)
+	if !ok {
+		conn.Close()
+		return nil, nil, fmt.Errorf("expected CONNACK, got %s", packetName(p2.packetType()))
+	}
+	if ack.ReasonCode == rcUnsupportedProtocol {
+		conn.Close()
+		return nil, nil, fmt.Errorf("%w: CONNACK reason %#x", errV5Unsupported, ack.ReasonCode)
+	}
+	return conn, ack, nil
+}
+
+// mustV5RawConnect 要求 CONNACK 原因码为 0，Broker 不支持 v5 时跳过；连接在测试结束时关闭。
+func mustV5RawConnect(t *testing.T, addr string, p *connectPacket) (*packetConn, *v5ConnackPacket) {
+	t.Helper()
+	if p.KeepAlive == 0 {
+		p.KeepAlive = 60
+	}
+	conn, ack, err := v5RawConnect(addr, p, 5*time.Second)
+	if errors.Is(err, errV5Unsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("v5 CONNECT %q: %v", p.ClientID, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if ack.ReasonCode != rcSuccess {
+		t.Fatalf("v5 CONNACK refused: reason %#x", ack.ReasonCode)
+	}
+	return conn, ack
+}
+
+// v5Subscribe 订阅单个过滤器并返回 SUBACK 原因码。
+func v5Subscribe(t *testing.T, conn *packetConn, id uint16, filter string, qos byte) byte {
+	t.Helper()
+	if err := conn.writePacket(&v5SubscribePacket{PacketID: id, Topics: []subscription{{Filter: filter, QoS: qos}}}); err != nil {
+		t.Fatalf("write SUBSCRIBE: %v", err)
+	}
+	ack, err := expectV5Packet[*v5SubackPacket](conn, 5*time.Second)
+	if err != nil {
+		t.Fatalf("waiting for SUBACK: %v", err)
+	}
+	if ack.Type != pktSUBACK || ack.PacketID != id || len(ack.Reasons) != 1 {
+		t.Fatalf("unexpected SUBACK: %+v", ack)
+	}
+	return ack.Reasons[0]
+}
+
+// v5Publish 发布一条消息，QoS 1 时等待 PUBACK、QoS 2 时完成 PUBREC/PUBREL/PUBCOMP，返回 PUBACK/PUBREC 的原因码。
+func v5Publish(t *testing.T, conn *packetConn, p *v5PublishPacket) byte {
+	t.Helper()
+	if err := conn.writePacket(p); err != nil {
+		t.Fatalf("write PUBLISH: %v", err)
+	}
+	if p.QoS == 0 {
+		return rcSuccess
+	}
+	want := pktPUBACK
+	if p.QoS == 2 {
+		want = pktPUBREC
+	}
+	ack := v5ExpectAck(t, conn, want, p.PacketID)
+	if p.QoS == 2 && ack.ReasonCode < rcUnspecifiedError {
+		_ = conn.writePacket(&v5AckPacket{Type: pktPUBREL, PacketID: p.PacketID})
+		v5ExpectAck(t, conn, pktPUBCOMP, p.PacketID)
+	}
+	return ack.ReasonCode
+}
+
+func v5ExpectAck(t *testing.T, conn *packetConn, typ byte, id uint16) *v5AckPacket {
+	t.Helper()
+	ack, err := expectV5Packet[*v5AckPacket](conn, 5*time.Second)
+	if err != nil {
+		t.Fatalf("waiting for %s: %v", packetName(typ), err)
+	}
+	if ack.Type != typ || ack.PacketID != id {
+		t.Fatalf("expected %s(%d), got %s(%d)", packetName(typ), id, packetName(ack.Type), ack.PacketID)
+	}
+	return ack
+}
+
+// v5ExpectPublish 等待下一条 PUBLISH 并完成确认：QoS 1 回复 PUBACK，QoS 2 完成 PUBREC/PUBREL/PUBCOMP。
+func v5ExpectPublish(t *testing.T, conn *packetConn, timeout time.Duration) *v5PublishPacket {
+	t.Helper()
+	p, err := expectV5Packet[*v5PublishPacket](conn, timeout)
+	if err != nil {
+		t.Fatalf("waiting for PUBLISH: %v", err)
+	}
+	switch p.QoS {
+	case 1:
+		_ = conn.writePacket(&v5AckPacket{Type: pktPUBACK, PacketID: p.PacketID})
+	case 2:
+		_ = conn.writePacket(&v5AckPacket{Type: pktPUBREC, PacketID: p.PacketID})
+		v5ExpectAck(t, conn, pktPUBREL, p.PacketID)
+		_ = conn.writePacket(&v5AckPacket{Type: pktPUBCOMP, PacketID: p.PacketID})
+	}
+	return p
+}
+
+// v5ExpectNoPublish 断言 d 时间内没有收到 PUBLISH (其他报文同样视为失败)。
+func v5ExpectNoPublish(t *testing.T, conn *packetConn, d time.Duration) {
+	t.Helper()
+	p, err := conn.readV5Packet(d)
+	if err == nil {
+		if pub, ok := p.(*v5PublishPacket); ok {
+			t.Fatalf("unexpected PUBLISH on %s: %q", pub.Topic, pub.Payload)
+		}
+		t.Fatalf("unexpected %s", packetName(p.packetType()))
+	}
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("reading v5 connection: %v", err)
+	}
+}
+
+// v5ExpectDisconnect 断言 Broker 发送指定原因码的 DISCONNECT 并关闭连接。
+func v5ExpectDisconnect(t *testing.T, conn *packetConn, reasons ...byte) {
+	t.Helper()
+	d, err := expectV5Packet[*v5DisconnectPacket](conn, 5*time.Second)
+	if err != nil {
+		t.Fatalf("waiting for DISCONNECT: %v", err)
+	}
+	ok := false
+	for _, rc := range reasons {
+		ok = ok || d.ReasonCode == rc
+	}
+	if !ok {
+		t.Fatalf("DISCONNECT reason %#x, want one of % x", d.ReasonCode, reasons)
+	}
+	expectClosed(t, conn, 5*time.Second)
+}
+
+func TestMQTT_PacketCodec_V5(t *testing.T) {
+	t.Run("Properties_RoundTrip", func(t *testing.T) {
+		props := v5Properties{
+			propPayloadFormat:     1,
+			propTopicAlias:        7,
+			propMessageExpiry:     3600,
+			propSubscriptionID:    268435455,
+			propTopicAliasMaximum: 10,
+		}
+		r := &bodyReader{b: props.append(nil)}
+		got := r.properties()
+		if r.err != nil || len(r.b) != 0 {
+			t.Fatalf("decode: %v (%d trailing bytes)", r.err, len(r.b))
+		}
+		for id, v := range props {
+			if got[id] != v {
+				t.Errorf("property %#x: got %d, want %d", id, got[id], v)
+			}
+		}
+	})
+
+	t.Run("Skip_String_Properties", func(t *testing.T) {
+		// 原因字符串 + 用户属性 + Receive Maximum
+		raw := []byte{propReasonString, 0, 2, 'o', 'k', propUserProperty, 0, 1, 'k', 0, 1, 'v', propReceiveMaximum, 0, 5}
+		r := &bodyReader{b: appendRemainingLength(nil, len(raw))}
+		r.b = append(r.b, raw...)
+		got := r.properties()
+		if r.err != nil || len(got) != 1 || got[propReceiveMaximum] != 5 {
+			t.Fatalf("got %v (%v), want only Receive Maximum = 5", got, r.err)
+		}
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		pkts := []mqttPacket{
+			&v5ConnackPacket{SessionPresent: true, Properties: v5Properties{propTopicAliasMaximum: 16}},
+			&v5PublishPacket{QoS: 1, PacketID: 9, Topic: "", Properties: v5Properties{propTopicAlias: 1}, Payload: []byte("x")},
+			&v5PublishPacket{Topic: "a/b", Properties: v5Properties{}, Payload: []byte{}},
+			&v5AckPacket{Type: pktPUBACK, PacketID: 9},
+			&v5AckPacket{Type: pktPUBACK, PacketID: 9, ReasonCode: rcNoMatchingSubscribers, Properties: v5Properties{}},
+			&v5AckPacket{Type: pktPUBREL, PacketID: 3, Properties: v5Properties{}},
+			&v5SubackPacket{Type: pktSUBACK, PacketID: 2, Properties: v5Properties{}, Reasons: []byte{1, rcTopicFilterInvalid}},
+			&v5SubackPacket{Type: pktUNSUBACK, PacketID: 2, Properties: v5Properties{}, Reasons: []byte{rcNoSubscriptionExisted}},
+			&v5DisconnectPacket{},
+			&v5DisconnectPacket{ReasonCode: rcTopicAliasInvalid, Properties: v5Properties{}},
+		}
+		for _, p := range pkts {
+			frame := encodePacket(p)
+			got, err := decodeV5Packet(frame[0], p.body())
+			if err != nil {
+				t.Errorf("%s: %v", packetName(p.packetType()), err)
+				continue
+			}
+			if a := encodePacket(got); string(a) != string(frame) {
+				t.Errorf("%s: re-encoded % x, want % x", packetName(p.packetType()), a, frame)
+			}
+		}
+	})
+
+	t.Run("Connect_Properties", func(t *testing.T) {
+		p := &connectPacket{ProtocolLevel: 5, CleanSession: true, KeepAlive: 30, ClientID: "c",
+			Properties: v5Properties{propSessionExpiry: 60}}
+		b := p.body()
+		// 协议名 (6) + 级别 (1) + 标志 (1) + Keep Alive (2)，随后是属性长度
+		r := &bodyReader{b: b[10:]}
+		if got := r.properties(); r.err != nil || got[propSessionExpiry] != 60 {
+			t.Fatalf("CONNECT properties = %v (%v), want Session Expiry Interval 60", got, r.err)
+		}
+		if id := r.string(); id != "c" {
+			t.Fatalf("client id after properties = %q, want c", id)
+		}
+	})
+}
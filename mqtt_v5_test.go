@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTT 5.0 变体：使用 paho.golang 在每个 endpoint 上验证 v5 的 CONNACK/SUBACK/PUBACK/UNSUBACK 原因码、
+// 服务端 DISCONNECT、带遗嘱断开 (0x04)，以及 3.1.1 与 5.0 客户端共享同一主题树和会话。
+// Broker 不支持协议级别 5 时 (CONNACK 0x84 / 3.1.1 的 0x01 或直接断开) 整组跳过。
+// 需求阻塞在 Broker 侧，见 mqtt_pending_test.go。
+
+// v5Client 封装 paho.golang 客户端，收到的 PUBLISH 和服务端 DISCONNECT 分别进入 msgs 和 disconnects。
+type v5Client struct {
+	*paho.Client
+	msgs        chan *paho.Publish
+	disconnects chan *paho.Disconnect
+}
+
+func v5Context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 5*time.Second)
+}
+
+// dialV5 连接 ep 并发送 cp，返回客户端和 CONNACK；CONNACK 原因码非 0 时 err 不为 nil。
+// cp 未设置用户名/密码时附加测试凭据。
+func dialV5(ep brokerEndpoint, cp *paho.Connect) (*v5Client, *paho.Connack, error) {
+	u, err := url.Parse(ep.url)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := dialTransport(u, *newClientOptions(ep.url, cp.ClientID, true))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !cp.UsernameFlag && !cp.PasswordFlag {
+		creds, _ := testCredentials()
+		user, pass := creds.forClient(cp.ClientID)
+		cp.Username, cp.UsernameFlag = user, user != ""
+		cp.Password, cp.PasswordFlag = []byte(pass), pass != ""
+	}
+	if !cp.CleanStart {
+		persistentSessions.Store(cp.ClientID, ep.url)
+	}
+
+	c := &v5Client{msgs: make(chan *paho.Publish, 64), disconnects: make(chan *paho.Disconnect, 1)}
+	c.Client = paho.NewClient(paho.ClientConfig{
+		Conn: conn,
+		OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+			func(pr paho.PublishReceived) (bool, error) {
+				select {
+				case c.msgs <- pr.Packet:
+				default:
+				}
+				return true, nil
+			},
+		},
+		OnServerDisconnect: func(d *paho.Disconnect) {
+			select {
+			case c.disconnects <- d:
+			default:
+			}
+		},
+		OnClientError: func(error) {},
+	})
+	ctx, cancel := v5Context()
+	defer cancel()
+	ca, err := c.Connect(ctx, cp)
+	if err != nil {
+		conn.Close()
+	}
+	return c, ca, err
+}
+
+func mustConnectV5(t *testing.T, ep brokerEndpoint, cp *paho.Connect) (*v5Client, *paho.Connack) {
+	t.Helper()
+	if cp.KeepAlive == 0 {
+		cp.KeepAlive = 60
+	}
+	c, ca, err := dialV5(ep, cp)
+	if err != nil {
+		t.Fatalf("v5 connect %s: %v", cp.ClientID, err)
+	}
+	t.Cleanup(func() { _ = c.Disconnect(&paho.Disconnect{}) })
+	return c, ca
+}
+
+func (c *v5Client) subscribe(t *testing.T, filter string, qos byte) byte {
+	t.Helper()
+	ctx, cancel := v5Context()
+	defer cancel()
+	sa, err := c.Subscribe(ctx, &paho.Subscribe{Subscriptions: []paho.SubscribeOptions{{Topic: filter, QoS: qos}}})
+	if sa == nil || len(sa.Reasons) != 1 {
+		t.Fatalf("subscribe %s: %v", filter, err)
+	}
+	return sa.Reasons[0]
+}
+
+func (c *v5Client) publish(t *testing.T, p *paho.Publish) byte {
+	t.Helper()
+	ctx, cancel := v5Context()
+	defer cancel()
+	resp, err := c.Publish(ctx, p)
+	if err != nil && (resp == nil || resp.ReasonCode < rcUnspecifiedError) {
+		t.Fatalf("publish %s: %v", p.Topic, err)
+	}
+	if resp == nil {
+		return 0
+	}
+	return resp.ReasonCode
+}
+
+func (c *v5Client) expectMessage(t *testing.T, topic string, payload []byte) *paho.Publish {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case p := <-c.msgs:
+			if p.Topic == topic && bytes.Equal(p.Payload, payload) {
+				return p
+			}
+		case <-timeout:
+			t.Fatalf("v5 message on %s not received", topic)
+		}
+	}
+}
+
+func (c *v5Client) expectNoMessage(t *testing.T, d time.Duration) {
+	t.Helper()
+	select {
+	case p := <-c.msgs:
+		t.Fatalf("unexpected message on %s", p.Topic)
+	case <-time.After(d):
+	}
+}
+
+func uint32Ptr(v uint32) *uint32 { return &v }
+
+// requireMQTT5 以协议级别 5 连接一次，Broker 不支持时跳过。
+func requireMQTT5(t *testing.T, ep brokerEndpoint) {
+	t.Helper()
+	c, ca, err := dialV5(ep, &paho.Connect{ClientID: "v5_probe_" + randSuffix(), CleanStart: true, KeepAlive: 30})
+	if err != nil {
+		if ca != nil && ca.ReasonCode != rcUnsupportedProtocol {
+			t.Fatalf("v5 CONNECT refused with reason %#x", ca.ReasonCode)
+		}
+		t.Skipf("broker does not accept MQTT 5 on %s: %v", ep.name, err)
+	}
+	_ = c.Disconnect(&paho.Disconnect{})
+}
+
+func testMQTT5(t *testing.T, ep brokerEndpoint) {
+	requireMQTT5(t, ep)
+
+	t.Run("Connack_Properties", func(t *testing.T) {
+		parallel(t)
+		_, ca := mustConnectV5(t, ep, &paho.Connect{ClientID: ep.name + "_v5conn_" + randSuffix(), CleanStart: true})
+		if ca.ReasonCode != rcSuccess || ca.SessionPresent {
+			t.Fatalf("CONNACK reason=%#x session present=%v, want 0x00 / false", ca.ReasonCode, ca.SessionPresent)
+		}
+		if p := ca.Properties; p != nil && p.TopicAliasMaximum != nil {
+			t.Logf("topic alias maximum %d", *p.TopicAliasMaximum)
+		}
+	})
+
+	for qos := byte(0); qos <= 2; qos++ {
+		qos := qos
+		t.Run("PubSub_QoS"+strconv.Itoa(int(qos)), func(t *testing.T) {
+			parallel(t)
+			topic := topicWithSuffix("cp7/test/v5_qos")
+			payload := []byte("v5_" + randSuffix())
+			c, _ := mustConnectV5(t, ep, &paho.Connect{ClientID: ep.name + "_v5pubsub_" + randSuffix(), CleanStart: true})
+			if rc := c.subscribe(t, topic, qos); rc != qos {
+				t.Fatalf("SUBACK reason %#x, want granted QoS %d", rc, qos)
+			}
+			if rc := c.publish(t, &paho.Publish{Topic: topic, QoS: qos, Payload: payload}); rc != rcSuccess {
+				t.Fatalf("publish reason %#x, want 0x00", rc)
+			}
+			if p := c.expectMessage(t, topic, payload); p.QoS != qos {
+				t.Fatalf("delivered at QoS %d, want %d", p.QoS, qos)
+			}
+		})
+	}
+
+	t.Run("Puback_No_Matching_Subscribers", func(t *testing.T) {
+		parallel(t)
+		c, _ := mustConnectV5(t, ep, &paho.Connect{ClientID: ep.name + "_v5nosub_" + randSuffix(), CleanStart: true})
+		// 0x10 (No matching subscribers) 和 0x00 都是合法的
+		if rc := c.publish(t, &paho.Publish{Topic: topicWithSuffix("cp7/test/v5_nosub"), QoS: 1, Payload: []byte("x")}); rc != rcSuccess && rc != rcNoMatchingSubscribers {
+			t.Fatalf("PUBACK reason %#x, want 0x00 or 0x10", rc)
+		}
+	})
+
+	t.Run("Suback_Invalid_Filter", func(t *testing.T) {
+		parallel(t)
+		c, _ := mustConnectV5(t, ep, &paho.Connect{ClientID: ep.name + "_v5badsub_" + randSuffix(), CleanStart: true})
+		if rc := c.subscribe(t, "cp7/test/#/v5", 1); rc != rcTopicFilterInvalid {
+			t.Fatalf("SUBACK reason %#x for invalid filter, want 0x8F (Topic Filter invalid)", rc)
+		}
+	})
+
+	t.Run("Unsuback_No_Subscription", func(t *testing.T) {
+		parallel(t)
+		c, _ := mustConnectV5(t, ep, &paho.Connect{ClientID: ep.name + "_v5unsub_" + randSuffix(), CleanStart: true})
+		ctx, cancel := v5Context()
+		defer cancel()
+		ua, err := c.Unsubscribe(ctx, &paho.Unsubscribe{Topics: []string{topicWithSuffix("cp7/test/v5_never")}})
+		if ua == nil || len(ua.Reasons) != 1 {
+			t.Fatalf("unsubscribe: %v", err)
+		}
+		if ua.Reasons[0] != rcNoSubscriptionExisted {
+			t.Fatalf("UNSUBACK reason %#x, want 0x11 (No subscription existed)", ua.Reasons[0])
+		}
+	})
+
+	t.Run("Interop_V3_V5", func(t *testing.T) {
+		parallel(t)
+		// 3.1.1 与 5.0 客户端共享同一主题树，双向投递
+		topic := topicWithSuffix("cp7/test/v5_interop")
+		v5, _ := mustConnectV5(t, ep, &paho.Connect{ClientID: ep.name + "_v5interop_" + randSuffix(), CleanStart: true})
+		v5.subscribe(t, topic, 1)
+
+		got3 := make(chan []byte, 4)
+		v3 := createClient(ep.url, ep.name+"_v3interop_"+randSuffix(), true)
+		mustConnect(t, v3, 5*time.Second)
+		defer v3.Disconnect(250)
+		mustWaitToken(t, v3.Subscribe(topic, 1, func(client mqtt.Client, msg mqtt.Message) {
+			got3 <- msg.Payload()
+		}), 5*time.Second, "subscribe")
+
+		mustWaitToken(t, v3.Publish(topic, 1, false, "from_v3"), 5*time.Second, "publish")
+		v5.expectMessage(t, topic, []byte("from_v3"))
+
+		v5.publish(t, &paho.Publish{Topic: topic, QoS: 1, Payload: []byte("from_v5")})
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case p := <-got3:
+				if string(p) == "from_v5" {
+					return
+				}
+			case <-timeout:
+				t.Fatal("v5 message not delivered to the 3.1.1 subscriber")
+			}
+		}
+	})
+
+	t.Run("Session_V3_Resumed_By_V5", func(t *testing.T) {
+		parallel(t)
+		id := ep.name + "_v3to5_" + randSuffix()
+		topic := topicWithSuffix("cp7/test/v5_session")
+		cleanupSession(t, ep.url, id)
+
+		c3 := createClient(ep.url, id, false)
+		mustConnect(t, c3, 5*time.Second)
+		mustWaitToken(t, c3.Subscribe(topic, 1, nil), 5*time.Second, "subscribe")
+		c3.Disconnect(250)
+
+		pub := createClient(ep.url, ep.name+"_v3to5pub_"+randSuffix(), true)
+		mustConnect(t, pub, 5*time.Second)
+		mustWaitToken(t, pub.Publish(topic, 1, false, "queued"), 5*time.Second, "publish")
+		pub.Disconnect(250)
+
+		c5, ca := mustConnectV5(t, ep, &paho.Connect{ClientID: id, CleanStart: false,
+			Properties: &paho.ConnectProperties{SessionExpiryInterval: uint32Ptr(300)}})
+		if !ca.SessionPresent {
+			t.Fatal("3.1.1 session not present for v5 reconnect with CleanStart=0")
+		}
+		c5.expectMessage(t, topic, []byte("queued"))
+	})
+
+	t.Run("Session_V5_Resumed_By_V3", func(t *testing.T) {
+		parallel(t)
+		id := ep.name + "_v5to3_" + randSuffix()
+		topic := topicWithSuffix("cp7/test/v5_session")
+		cleanupSession(t, ep.url, id)
+
+		c5, _, err := dialV5(ep, &paho.Connect{ClientID: id, CleanStart: true, KeepAlive: 60,
+			Properties: &paho.ConnectProperties{SessionExpiryInterval: uint32Ptr(300)}})
+		if err != nil {
+			t.Fatalf("v5 connect: %v", err)
+		}
+		c5.subscribe(t, topic, 1)
+		_ = c5.Disconnect(&paho.Disconnect{})
+
+		rec := &payloadRecorder{}
+		opts := newClientOptions(ep.url, id, false)
+		opts.SetDefaultPublishHandler(rec.handler)
+		c3 := mqtt.NewClient(opts)
+		tok := c3.Connect()
+		mustWaitToken(t, tok, 5*time.Second, "connect")
+		defer c3.Disconnect(250)
+		if ct, ok := tok.(*mqtt.ConnectToken); ok && !ct.SessionPresent() {
+			t.Fatal("v5 session not present for 3.1.1 reconnect with CleanSession=0")
+		}
+
+		pub, _ := mustConnectV5(t, ep, &paho.Connect{ClientID: ep.name + "_v5to3pub_" + randSuffix(), CleanStart: true})
+		pub.publish(t, &paho.Publish{Topic: topic, QoS: 1, Payload: []byte("to_v3")})
+		if !rec.waitCount(1, 10*time.Second) {
+			t.Fatal("v5 subscription not honoured by the resumed 3.1.1 session")
+		}
+	})
+
+	t.Run("Disconnect_With_Will", func(t *testing.T) {
+		parallel(t)
+		// v5 DISCONNECT 原因码 0x04 要求 Broker 发布遗嘱，0x00 则丢弃遗嘱
+		for _, tc := range []struct {
+			name   string
+			reason byte
+			want   bool
+		}{{"Reason_0x04", rcDisconnectWithWill, true}, {"Reason_0x00", rcNormalDisconnection, false}} {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				parallel(t)
+				willTopic := topicWithSuffix("cp7/test/v5_will")
+				sub, _ := mustConnectV5(t, ep, &paho.Connect{ClientID: ep.name + "_v5willsub_" + randSuffix(), CleanStart: true})
+				sub.subscribe(t, willTopic, 1)
+
+				c, _, err := dialV5(ep, &paho.Connect{ClientID: ep.name + "_v5will_" + randSuffix(), CleanStart: true, KeepAlive: 60,
+					WillMessage: &paho.WillMessage{Topic: willTopic, QoS: 1, Payload: []byte("gone")}})
+				if err != nil {
+					t.Fatalf("v5 connect: %v", err)
+				}
+				_ = c.Disconnect(&paho.Disconnect{ReasonCode: tc.reason})
+
+				if tc.want {
+					sub.expectMessage(t, willTopic, []byte("gone"))
+				} else {
+					sub.expectNoMessage(t, 2*time.Second)
+				}
+			})
+		}
+	})
+
+	t.Run("Server_Disconnect_Session_Takeover", func(t *testing.T) {
+		parallel(t)
+		// 相同 ClientId 的新连接接管会话，旧连接应收到原因码 0x8E 的 DISCONNECT
+		id := ep.name + "_v5takeover_" + randSuffix()
+		old, _ := mustConnectV5(t, ep, &paho.Connect{ClientID: id, CleanStart: true})
+		mustConnectV5(t, ep, &paho.Connect{ClientID: id, CleanStart: true})
+		select {
+		case d := <-old.disconnects:
+			if d.ReasonCode != rcSessionTakenOver {
+				t.Fatalf("DISCONNECT reason %#x, want 0x8E (Session taken over)", d.ReasonCode)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("old connection did not receive a server DISCONNECT")
+		}
+	})
+}